@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// Maps the progress of an animation (0.0 - 1.0) to how far the sizes have moved
+type easing func(t float64) float64
+
+var easings = map[string]easing{
+	"linear":  func(t float64) float64 { return t },
+	"ease-in": func(t float64) float64 { return t * t },
+	"ease-out": func(t float64) float64 {
+		return 1 - (1-t)*(1-t)
+	},
+	"ease-in-out": func(t float64) float64 {
+		if t < 0.5 {
+			return 2 * t * t
+		}
+		return 1 - math.Pow(-2*t+2, 2)/2
+	},
+}
+
+func lookupEasing(name string) (easing, error) {
+	ease, ok := easings[name]
+	if !ok {
+		names := make([]string, 0, len(easings))
+		for name := range easings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown easing %q, expected one of: %s", name, strings.Join(names, ", "))
+	}
+	return ease, nil
+}
+
+// Renders the models in frames, moving the items from their sizes
+// as of the last sync to their new ones over the duration.
+//
+// Frames are rendered in the background, so Render returns right away.
+// Rendering again or settling stops the animation in progress, and the next starts from where it was.
+type animatedRenderer struct {
+	renderer FlexRenderer // Renders each frame
+	frames   int
+	duration time.Duration
+	ease     easing
+
+	mu       sync.Mutex
+	rendered map[i3.NodeID]Size // Sizes of the items in the last frame rendered
+	final    []*FlexModel       // The last frame of the animation in progress, nil once it's been rendered
+	err      error              // From rendering the last frame rendered, until Settle returns it
+	cancel   chan struct{}      // Closed to stop the animation in progress
+	done     chan struct{}      // Closed once the animation in progress has stopped
+}
+
+func newAnimatedRenderer(renderer FlexRenderer, frames int, duration time.Duration, ease easing) *animatedRenderer {
+	return &animatedRenderer{
+		renderer: renderer,
+		frames:   frames,
+		duration: duration,
+		ease:     ease,
+		rendered: make(map[i3.NodeID]Size),
+	}
+}
+
+func (r *animatedRenderer) Render(models []*FlexModel) error {
+	r.stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := make([][]Size, 0, len(models))
+	final := make([]*FlexModel, 0, len(models))
+	for _, model := range models {
+		sizes := make([]Size, 0, len(model.items))
+		for _, item := range model.items {
+			size, ok := r.rendered[item.id]
+			if !ok {
+				size = item.previous
+			}
+			sizes = append(sizes, size)
+		}
+		start = append(start, sizes)
+		final = append(final, frameModel(model, nil))
+	}
+
+	// Until the first frame, the items are where they started
+	r.rendered = make(map[i3.NodeID]Size)
+	for i, model := range models {
+		for j, item := range model.items {
+			r.rendered[item.id] = start[i][j]
+		}
+	}
+	r.final = final
+	r.err = nil
+	r.cancel = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.animate(start, final, r.cancel, r.done)
+	return nil
+}
+
+// Stops the animation in progress at the last frame rendered.
+// It has to be called before reading the layout back from i3, and the sizes returned expected there,
+// or the sizes in the middle of the animation would look like resizes by the user.
+// Returns nil sizes if the animation had finished, and the error from rendering the last frame.
+func (r *animatedRenderer) Settle() (map[i3.NodeID]Size, error) {
+	r.stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var interrupted map[i3.NodeID]Size
+	if r.final != nil {
+		interrupted = make(map[i3.NodeID]Size)
+		for _, model := range r.final {
+			for _, item := range model.items {
+				interrupted[item.id] = r.rendered[item.id]
+			}
+		}
+	}
+	err := r.err
+	r.final = nil
+	r.err = nil
+	return interrupted, err
+}
+
+// Stops the animation in progress, if any, and waits for it
+func (r *animatedRenderer) stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	close(cancel)
+	<-done
+}
+
+func (r *animatedRenderer) animate(start [][]Size, final []*FlexModel, cancel chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(r.duration / time.Duration(r.frames))
+	defer ticker.Stop()
+
+	for frame := 1; frame <= r.frames; frame++ {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+		}
+
+		t := r.ease(float64(frame) / float64(r.frames))
+		models := make([]*FlexModel, 0, len(final))
+		for i, model := range final {
+			models = append(models, frameModel(model, interpolate(start[i], model, t)))
+		}
+
+		r.mu.Lock()
+		for _, model := range models {
+			for _, item := range model.items {
+				r.rendered[item.id] = item.current
+			}
+		}
+		err := r.renderer.Render(models)
+		r.err = err
+		if frame == r.frames {
+			r.final = nil
+		}
+		r.mu.Unlock()
+		if err != nil {
+			logError(logRenderer, "Error rendering frame %d of %d: %s", frame, r.frames, err.Error())
+		}
+	}
+}
+
+// Copies what's needed to render the model, with the items resized to sizes, or as they are if nil.
+// Frames are rendered while the daemon goes on changing the models, so they can't be shared.
+func frameModel(model *FlexModel, sizes []Size) *FlexModel {
+	items := make([]*FlexItem, 0, len(model.items))
+	for i, item := range model.items {
+		size := item.current
+		if sizes != nil {
+			size = sizes[i]
+		}
+		items = append(items, &FlexItem{id: item.id, current: size})
+	}
+	return &FlexModel{id: model.id, direction: model.direction, items: items}
+}
+
+// Returns the sizes t of the way from start to the model's sizes.
+// Rounding leftovers go to the largest item, so they add up to normal.
+func interpolate(start []Size, model *FlexModel, t float64) []Size {
+	sizes := make([]Size, len(model.items))
+	total := Size(0)
+	largest := 0
+	for i, item := range model.items {
+		sizes[i] = start[i] + Size(float64(item.current-start[i])*t)
+		total = total + sizes[i]
+		if item.current > model.items[largest].current {
+			largest = i
+		}
+	}
+	sizes[largest] = sizes[largest] + normal - total
+	return sizes
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the sizes of the first item of every frame
+type recordingRenderer struct {
+	mu     sync.Mutex
+	frames []Size
+}
+
+func (r *recordingRenderer) Render(models []*FlexModel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, models[0].items[0].current)
+	return nil
+}
+
+func TestAnimationFrames(t *testing.T) {
+	recorder := &recordingRenderer{}
+	r := newAnimatedRenderer(recorder, 4, 4*time.Millisecond, easings["linear"])
+	f := testModel(defaultGlobalSizings(), 800, 200)
+	f.items[0].previous, f.items[1].previous = 400, 600
+	r.Render([]*FlexModel{f})
+	<-r.done
+
+	expected := []Size{500, 600, 700, 800}
+	if len(recorder.frames) != len(expected) {
+		t.Fatalf("expected frames %v, got %v", expected, recorder.frames)
+	}
+	for i := range expected {
+		if recorder.frames[i] != expected[i] {
+			t.Fatalf("expected frames %v, got %v", expected, recorder.frames)
+		}
+	}
+}
+
+func TestSettleStopsAtLastFrameRendered(t *testing.T) {
+	recorder := &recordingRenderer{}
+	r := newAnimatedRenderer(recorder, 10, time.Hour, easings["linear"])
+	f := testModel(defaultGlobalSizings(), 800, 200)
+	f.items[0].previous, f.items[1].previous = 400, 600
+	r.Render([]*FlexModel{f})
+	sizes, _ := r.Settle()
+
+	if len(recorder.frames) != 0 {
+		t.Errorf("expected settling not to render, got frames %v", recorder.frames)
+	}
+	if sizes[1] != 400 || sizes[2] != 600 {
+		t.Errorf("expected the items to be left where they started, got %v", sizes)
+	}
+	if sizes, _ := r.Settle(); sizes != nil {
+		t.Errorf("expected nothing left partway once settled, got %v", sizes)
+	}
+}
+
+func TestAnimationStartsFromSettledSizes(t *testing.T) {
+	recorder := &recordingRenderer{}
+	r := newAnimatedRenderer(recorder, 2, 200*time.Millisecond, easings["linear"])
+	f := testModel(defaultGlobalSizings(), 800, 200)
+	f.items[0].previous, f.items[1].previous = 400, 600
+	r.Render([]*FlexModel{f})
+	for {
+		recorder.mu.Lock()
+		n := len(recorder.frames)
+		recorder.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sizes, _ := r.Settle()
+	if sizes[1] != 600 {
+		t.Fatalf("expected the first item to be left at 600, got %v", sizes)
+	}
+
+	r.duration = 2 * time.Millisecond
+	f.items[0].current, f.items[1].current = 300, 700
+	r.Render([]*FlexModel{f})
+	<-r.done
+	if len(recorder.frames) != 3 || recorder.frames[1] != 450 || recorder.frames[2] != 300 {
+		t.Errorf("expected the next animation to start from the settled 600, got frames %v", recorder.frames)
+	}
+}
+
+func TestSettledModelsAreFinished(t *testing.T) {
+	fm := initFlexModels(defaultGlobalSizings())
+	r := newAnimatedRenderer(&recordingRenderer{}, 10, time.Hour, easings["linear"])
+	fm.RegisterRenderer(r)
+	update := FlexUpdate{ExternalId: 1, Direction: Horizontal, Items: []FlexItemUpdate{
+		{ExternalId: 2, Size: 500}, {ExternalId: 3, Size: 500},
+	}}
+	fm.Updates([]FlexUpdate{update}, true)
+	fm.OnFocus(2)
+	fm.Settle()
+	item := fm.models[1].items[0]
+	if item.expected != 500 {
+		t.Errorf("expected the item where the animation left it, got %d", item.expected)
+	}
+
+	// Reading the layout back isn't a resize by the user, and the animation goes on
+	fm.Updates([]FlexUpdate{update}, true)
+	if !isFlexed(item.current) {
+		t.Errorf("expected the item to stay flexed, got %d", item.current)
+	}
+	if sizes, _ := r.Settle(); sizes[2] != 500 {
+		t.Errorf("expected the model to be rendered again, got %v", sizes)
+	}
+}
+
+// Rejects every resize of the first item
+type rejectingRenderer struct{}
+
+func (rejectingRenderer) Render(models []*FlexModel) error {
+	id := models[0].items[0].id
+	return &RenderError{[]resizeFailure{{resizeCommand{id, ""}, "rejected"}}}
+}
+
+func TestSettleReturnsLastFrameError(t *testing.T) {
+	fm := initFlexModels(defaultGlobalSizings())
+	fm.RegisterRenderer(newAnimatedRenderer(rejectingRenderer{}, 2, 2*time.Millisecond, easings["linear"]))
+	fm.Updates([]FlexUpdate{{ExternalId: 1, Direction: Horizontal, Items: []FlexItemUpdate{
+		{ExternalId: 2, Size: 500}, {ExternalId: 3, Size: 500},
+	}}}, true)
+	fm.OnFocus(2)
+	<-fm.renderer.(*animatedRenderer).done
+
+	fm.Settle()
+	if expected := fm.models[1].items[0].expected; expected != 0 {
+		t.Errorf("expected the rejected resize not to be expected anymore, got %d", expected)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// Where flexing is turned on or off, e.g.
+//
+//	[areas]
+//	disabled_workspaces = ["^media$", "^10$"]
+//	disabled_outputs = ["^HDMI-"]
+//
+// Patterns are regular expressions, matched against workspace and output names.
+// When any enabled_ patterns are given, only the matching workspaces or outputs are flexed.
+type AreaConfig struct {
+	EnabledWorkspaces  []string `toml:"enabled_workspaces"`
+	DisabledWorkspaces []string `toml:"disabled_workspaces"`
+	EnabledOutputs     []string `toml:"enabled_outputs"`
+	DisabledOutputs    []string `toml:"disabled_outputs"`
+}
+
+type areaKind string
+
+const (
+	workspaceArea areaKind = "workspace"
+	outputArea    areaKind = "output"
+)
+
+type areaKey struct {
+	kind areaKind
+	name string
+}
+
+// Decides which workspaces and outputs get flexed
+type areaFilter struct {
+	enabled   map[areaKind][]*regexp.Regexp
+	disabled  map[areaKind][]*regexp.Regexp
+	overrides map[areaKey]bool // Set at runtime by name, taking precedence over the patterns
+}
+
+func (c AreaConfig) compile() (*areaFilter, error) {
+	filter := newAreaFilter()
+	lists := []struct {
+		name     string
+		patterns []string
+		kind     areaKind
+		into     map[areaKind][]*regexp.Regexp
+	}{
+		{"enabled_workspaces", c.EnabledWorkspaces, workspaceArea, filter.enabled},
+		{"disabled_workspaces", c.DisabledWorkspaces, workspaceArea, filter.disabled},
+		{"enabled_outputs", c.EnabledOutputs, outputArea, filter.enabled},
+		{"disabled_outputs", c.DisabledOutputs, outputArea, filter.disabled},
+	}
+	for _, list := range lists {
+		for _, pattern := range list.patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("areas.%s: %w", list.name, err)
+			}
+			list.into[list.kind] = append(list.into[list.kind], re)
+		}
+	}
+	return filter, nil
+}
+
+// A filter which enables everything
+func newAreaFilter() *areaFilter {
+	return &areaFilter{
+		enabled:   make(map[areaKind][]*regexp.Regexp),
+		disabled:  make(map[areaKind][]*regexp.Regexp),
+		overrides: make(map[areaKey]bool),
+	}
+}
+
+// Whether containers on the workspace and output should be flexed
+func (a *areaFilter) allows(workspace string, output string) bool {
+	return a.allowsArea(outputArea, output) && a.allowsArea(workspaceArea, workspace)
+}
+
+func (a *areaFilter) allowsArea(kind areaKind, name string) bool {
+	if enabled, ok := a.overrides[areaKey{kind, name}]; ok {
+		return enabled
+	}
+	if enabled := a.enabled[kind]; len(enabled) > 0 && !matchesAny(enabled, name) {
+		return false
+	}
+	return !matchesAny(a.disabled[kind], name)
+}
+
+// Turns flexing on or off for a single workspace or output, until the daemon exits
+func (a *areaFilter) set(kind areaKind, name string, enabled bool) {
+	a.overrides[areaKey{kind, name}] = enabled
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names of the workspace and output on the path, where they exist
+func locate(path []*i3.Node) (workspace string, output string) {
+	for _, node := range path {
+		switch node.Type {
+		case i3.OutputNode:
+			output = node.Name
+		case i3.WorkspaceNode:
+			workspace = node.Name
+		}
+	}
+	return workspace, output
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"time"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// Handles events from i3 until the channel is closed, or the daemon should stop.
+//
+// Window events arriving within the window of the first one are handled together,
+// so moving focus quickly only syncs and renders once, for wherever the focus ended up.
+// Anything queued up behind a slow sync or render joins the burst too, superseding it.
+// Other events are handled in order, after the burst before them.
+func (d *daemon) handleEvents(events <-chan i3.Event, window time.Duration) {
+	var (
+		burst []*i3.WindowEvent
+		timer <-chan time.Time
+	)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				d.onWindowEvents(burst)
+				return
+			}
+			if ev, isWindow := event.(*i3.WindowEvent); isWindow && window > 0 {
+				if len(burst) == 0 {
+					timer = time.After(window)
+				}
+				burst = append(burst, ev)
+				continue
+			}
+			if !d.onWindowEvents(burst) {
+				return
+			}
+			burst, timer = nil, nil
+			if !d.onEvent(event) {
+				return
+			}
+		case <-timer:
+			if !d.onWindowEvents(burst) {
+				return
+			}
+			burst, timer = nil, nil
+		}
+	}
+}
+
+// Handles a burst of window events at once. Returns false once the daemon should stop.
+func (d *daemon) onWindowEvents(burst []*i3.WindowEvent) (proceed bool) {
+	switch len(burst) {
+	case 0:
+		return true
+	case 1:
+		return d.onEvent(burst[0])
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.recoverFrom(burst, &proceed)
+	if !d.active() {
+		return !d.exiting
+	}
+	logDebug(logEvents, "Coalescing %d window events", len(burst))
+
+	var err error
+	if containersIn(burst) == 1 {
+		err = d.syncContainer(burst[0].Container.ID)
+	} else {
+		// One full sync beats getting the tree for each container
+		err = d.sync()
+	}
+	if err != nil {
+		logError(logEvents, "Error syncing window events: %s", err.Error())
+	} else if target, ok := focusedIn(burst); ok {
+		d.fm.OnFocus(target)
+	}
+	d.changed()
+	return !d.exiting
+}
+
+// Returns the window focused last in the burst, if any was.
+// Only focusing flexes, other changes like a new title just sync.
+func focusedIn(burst []*i3.WindowEvent) (i3.NodeID, bool) {
+	for i := len(burst) - 1; i >= 0; i-- {
+		if burst[i].Change == "focus" {
+			return burst[i].Container.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Returns how many different containers the events are for
+func containersIn(burst []*i3.WindowEvent) int {
+	ids := make(map[i3.NodeID]bool)
+	for _, ev := range burst {
+		ids[ev.Container.ID] = true
+	}
+	return len(ids)
+}
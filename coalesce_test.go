@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"go.i3wm.org/i3/v4"
+)
+
+func TestOnlyFocusFlexes(t *testing.T) {
+	window := func(change string, id i3.NodeID) *i3.WindowEvent {
+		return &i3.WindowEvent{Change: change, Container: i3.Node{ID: id}}
+	}
+	for _, test := range []struct {
+		name   string
+		burst  []*i3.WindowEvent
+		flexed i3.NodeID // 0 for none
+	}{
+		{"title", []*i3.WindowEvent{window("title", 8)}, 0},
+		{"titles", []*i3.WindowEvent{window("title", 8), window("title", 5)}, 0},
+		{"urgent and mark", []*i3.WindowEvent{window("urgent", 7), window("mark", 7)}, 0},
+		{"focus", []*i3.WindowEvent{window("focus", 8)}, 8},
+		{"focus then title", []*i3.WindowEvent{window("focus", 8), window("title", 5)}, 8},
+		{"new then focus", []*i3.WindowEvent{window("new", 7), window("focus", 7), window("title", 7)}, 7},
+	} {
+		m := loadTestMock(t)
+		d := newDaemon(initFlexModels(defaultGlobalSizings()), "")
+		d.getTree = func() (i3.Tree, error) { return i3.Tree{Root: m.tree}, nil }
+		if err := d.sync(); err != nil {
+			t.Fatal(err)
+		}
+		d.onWindowEvents(test.burst)
+
+		for _, id := range []i3.NodeID{5, 7, 8} {
+			model, i := d.fm.findItem(id)
+			if model == nil {
+				t.Fatalf("%s: expected [%d] to be modelled", test.name, id)
+			}
+			if flexed := isFlexed(model.items[i].current); flexed != (id == test.flexed) {
+				t.Errorf("%s: unexpected size %d of [%d]", test.name, model.items[i].current, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The user configuration, as read from the config file.
+//
+// All sizes in the file are fractions of the container (0.0 - 1.0),
+// and get converted to the normal scale when loaded.
+type Config struct {
+	Sizing    SizingConfig    `toml:"sizing"`
+	Daemon    DaemonConfig    `toml:"daemon"`
+	Rules     []RuleConfig    `toml:"rules"`
+	Areas     AreaConfig      `toml:"areas"`
+	Focus     FocusConfig     `toml:"focus"`
+	Animation AnimationConfig `toml:"animation"`
+	Log       LogConfig       `toml:"log"`
+
+	sizings     GlobalSizings
+	rules       []windowRule
+	areas       *areaFilter
+	propagation focusPropagation
+	logLevels   logLevels
+}
+
+// Overrides for GlobalSizings. Unset (nil) values keep their defaults.
+type SizingConfig struct {
+	DefaultFlexRatio *float64 `toml:"default_flex_ratio"`
+	MaxFlex          *float64 `toml:"max_flex"`
+	SoftMinFlex      *float64 `toml:"soft_min_flex"`
+	HardMinFlex      *float64 `toml:"hard_min_flex"`
+	SoftMinUnflex    *float64 `toml:"soft_min_unflex"`
+	HardMinUnflex    *float64 `toml:"hard_min_unflex"`
+	ResizeTolerance  *float64 `toml:"resize_tolerance"`
+
+	// Which items get the space when the user shrinks one: proportional (the default), flexed or unflexed
+	ShrinkPolicy string `toml:"shrink_policy"`
+
+	// How items are sized on focus: constraints (the default), equal, golden, fixed or recency
+	Strategy string `toml:"strategy"`
+}
+
+// How focusing an item flexes the containers around it
+type FocusConfig struct {
+	// How many levels of ancestors above the focused item's container get flexed too.
+	// 0 flexes every one up to the workspace.
+	MaxDepth int `toml:"max_depth"`
+	// Whether ancestors split in the same direction as the container below them get flexed
+	SameDirection bool `toml:"same_direction"`
+}
+
+// Animates resizes when the duration is set
+type AnimationConfig struct {
+	Duration int    `toml:"duration"` // In milliseconds. 0 resizes in one step
+	Frames   int    `toml:"frames"`
+	Easing   string `toml:"easing"` // linear, ease-in, ease-out or ease-in-out
+}
+
+type LogConfig struct {
+	Level string `toml:"level"` // error, warn, info, debug or trace
+	// Levels for single categories, overriding level: traverser, model, rebalance, renderer or events
+	Categories map[string]string `toml:"categories"`
+	// Logs go to this file instead of stderr when set
+	File       string `toml:"file"`
+	MaxSize    int    `toml:"max_size"`    // In megabytes. The file is rotated once it grows past it, 0 never rotates it
+	MaxBackups int    `toml:"max_backups"` // How many rotated files are kept
+}
+
+type DaemonConfig struct {
+	// Seconds between full syncs with the i3 tree, on top of the incremental updates done per event.
+	// 0 disables them.
+	ResyncInterval int `toml:"resync_interval"`
+	// Milliseconds over which bursts of window events are handled as one. 0 handles each on its own.
+	CoalesceWindow int `toml:"coalesce_window"`
+}
+
+// Returns $XDG_CONFIG_HOME/i3-flex/config.toml, falling back to ~/.config
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "i3-flex", "config.toml")
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Daemon: DaemonConfig{
+			ResyncInterval: 60,
+			CoalesceWindow: 30,
+		},
+		Focus: FocusConfig{
+			SameDirection: true,
+		},
+		Animation: AnimationConfig{
+			Frames: 10,
+			Easing: "ease-out",
+		},
+		Log: LogConfig{
+			Level:      "info",
+			MaxSize:    10,
+			MaxBackups: 3,
+		},
+		sizings: defaultGlobalSizings(),
+		areas:   newAreaFilter(),
+
+		propagation: focusPropagation{sameDirection: true},
+		logLevels:   logLevels{level: levelInfo},
+	}
+}
+
+// Loads and validates the config at path.
+//
+// If the file doesn't exist and it's not required, the defaults are returned.
+func loadConfig(path string, required bool) (*Config, error) {
+	config := defaultConfig()
+	if path == "" {
+		return config, nil
+	}
+	md, err := toml.DecodeFile(path, config)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return config, nil
+		}
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return nil, fmt.Errorf("config %s: unknown keys: %s", path, strings.Join(keys, ", "))
+	}
+
+	if err := config.compile(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Loads the config given by a -config flag, which is required to exist if given.
+// Otherwise loads the default config, if there is one.
+func loadConfigFlag(path string) (*Config, error) {
+	if path != "" {
+		return loadConfig(path, true)
+	}
+	return loadConfig(defaultConfigPath(), false)
+}
+
+// Validates the decoded config, and converts it to what the daemon works with
+func (c *Config) compile() error {
+	var err error
+	c.sizings, err = c.Sizing.apply(defaultGlobalSizings())
+	if err != nil {
+		return err
+	}
+	if err := c.Daemon.validate(); err != nil {
+		return err
+	}
+	c.rules = make([]windowRule, 0, len(c.Rules))
+	for i, ruleConfig := range c.Rules {
+		rule, err := ruleConfig.compile(c.sizings)
+		if err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		c.rules = append(c.rules, rule)
+	}
+	c.areas, err = c.Areas.compile()
+	if err != nil {
+		return err
+	}
+	if c.Focus.MaxDepth < 0 {
+		return fmt.Errorf("focus.max_depth must not be negative, got %d", c.Focus.MaxDepth)
+	}
+	c.propagation = focusPropagation{
+		maxDepth:      c.Focus.MaxDepth,
+		sameDirection: c.Focus.SameDirection,
+	}
+	c.logLevels, err = c.Log.compile()
+	if err != nil {
+		return err
+	}
+	return c.Animation.validate()
+}
+
+func (c LogConfig) compile() (logLevels, error) {
+	level, err := parseLevel(c.Level)
+	if err != nil {
+		return logLevels{}, fmt.Errorf("log.level: %w", err)
+	}
+	levels := logLevels{level: level}
+	for category, levelName := range c.Categories {
+		if levels, err = levels.with(category, levelName); err != nil {
+			return levels, fmt.Errorf("log.categories: %w", err)
+		}
+	}
+	if c.MaxSize < 0 {
+		return levels, fmt.Errorf("log.max_size must not be negative, got %d", c.MaxSize)
+	}
+	if c.MaxBackups < 0 {
+		return levels, fmt.Errorf("log.max_backups must not be negative, got %d", c.MaxBackups)
+	}
+	return levels, nil
+}
+
+// Sets the levels in force to the config's, overridden by spec as given to a -log flag
+func (c *Config) useLogLevels(spec string) error {
+	levels, err := parseLevels(spec, c.logLevels)
+	if err != nil {
+		return fmt.Errorf("-log: %w", err)
+	}
+	logging = levels
+	return nil
+}
+
+// Sends the logs to the file, rotating it as configured. Returns the file, to be closed on exit.
+func (c LogConfig) openFile(path string) (*rotatingFile, error) {
+	file, err := openRotatingFile(path, int64(c.MaxSize)<<20, c.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(file)
+	return file, nil
+}
+
+func (c AnimationConfig) validate() error {
+	if c.Duration < 0 {
+		return fmt.Errorf("animation.duration must not be negative, got %d", c.Duration)
+	}
+	if c.Frames < 1 {
+		return fmt.Errorf("animation.frames must be at least 1, got %d", c.Frames)
+	}
+	if c.Duration > 0 && c.Duration < c.Frames {
+		return fmt.Errorf("animation.duration (%dms) must be at least 1ms per frame (%d)", c.Duration, c.Frames)
+	}
+	if _, err := lookupEasing(c.Easing); err != nil {
+		return fmt.Errorf("animation.easing: %w", err)
+	}
+	return nil
+}
+
+// Wraps the renderer in one which animates, if animations are enabled
+func (c AnimationConfig) renderer(renderer FlexRenderer) FlexRenderer {
+	if c.Duration == 0 {
+		return renderer
+	}
+	ease, _ := lookupEasing(c.Easing) // Checked by validate
+	return newAnimatedRenderer(renderer, c.Frames, time.Duration(c.Duration)*time.Millisecond, ease)
+}
+
+func (c DaemonConfig) validate() error {
+	if c.ResyncInterval < 0 {
+		return fmt.Errorf("daemon.resync_interval must not be negative, got %d", c.ResyncInterval)
+	}
+	if c.CoalesceWindow < 0 {
+		return fmt.Errorf("daemon.coalesce_window must not be negative, got %d", c.CoalesceWindow)
+	}
+	return nil
+}
+
+// Applies the overrides to sizings, and validates the result
+func (c SizingConfig) apply(sizings GlobalSizings) (GlobalSizings, error) {
+	fields := []struct {
+		name  string
+		value *float64
+		size  *Size
+	}{
+		{"max_flex", c.MaxFlex, &sizings.maxFlex},
+		{"soft_min_flex", c.SoftMinFlex, &sizings.softMinFlex},
+		{"hard_min_flex", c.HardMinFlex, &sizings.hardMinFlex},
+		{"soft_min_unflex", c.SoftMinUnflex, &sizings.softMinUnflex},
+		{"hard_min_unflex", c.HardMinUnflex, &sizings.hardMinUnflex},
+		{"resize_tolerance", c.ResizeTolerance, &sizings.resizeTolerance},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		size, err := fractionToSize("sizing."+field.name, *field.value)
+		if err != nil {
+			return sizings, err
+		}
+		*field.size = size
+	}
+	if c.DefaultFlexRatio != nil {
+		size, err := fractionToSize("sizing.default_flex_ratio", *c.DefaultFlexRatio)
+		if err != nil {
+			return sizings, err
+		}
+		sizings.defaultFlexRatio = Ratio{int(size), normal}
+	}
+	switch policy := shrinkPolicy(c.ShrinkPolicy); policy {
+	case "":
+	case proportionalShrinkPolicy, flexedShrinkPolicy, unflexedShrinkPolicy:
+		sizings.shrinkPolicy = policy
+	default:
+		return sizings, fmt.Errorf("sizing.shrink_policy must be one of proportional, flexed or unflexed, got %q", c.ShrinkPolicy)
+	}
+	if c.Strategy != "" {
+		strategy, err := lookupSizing(c.Strategy)
+		if err != nil {
+			return sizings, fmt.Errorf("sizing.strategy: %w", err)
+		}
+		sizings.strategy = strategy
+	}
+
+	return sizings, sizings.validate()
+}
+
+func fractionToSize(name string, fraction float64) (Size, error) {
+	if fraction <= 0 || fraction > 1 {
+		return 0, fmt.Errorf("%s must be a fraction between 0 and 1, got %g", name, fraction)
+	}
+	return Size(math.Round(fraction * normal)), nil
+}
+
+// Checks that the sizings are consistent with one another,
+// i.e. that a flexed item is always the largest, and that the minimums can fit in a container.
+func (g GlobalSizings) validate() error {
+	fraction := func(s Size) float64 { return float64(s) / normal }
+	defaultFlex := Size(g.defaultFlexRatio.Normalize())
+
+	switch {
+	case g.hardMinUnflex <= 0:
+		return fmt.Errorf("sizing.hard_min_unflex must be positive")
+	case g.resizeTolerance >= g.hardMinUnflex:
+		return fmt.Errorf("sizing.resize_tolerance (%g) must be less than sizing.hard_min_unflex (%g)",
+			fraction(g.resizeTolerance), fraction(g.hardMinUnflex))
+	case g.hardMinFlex <= normal/2:
+		return fmt.Errorf("sizing.hard_min_flex (%g) must be greater than 0.5, or flexed items may not be the largest",
+			fraction(g.hardMinFlex))
+	case g.softMinFlex < g.hardMinFlex:
+		return fmt.Errorf("sizing.soft_min_flex (%g) must be at least sizing.hard_min_flex (%g)",
+			fraction(g.softMinFlex), fraction(g.hardMinFlex))
+	case g.softMinUnflex < g.hardMinUnflex:
+		return fmt.Errorf("sizing.soft_min_unflex (%g) must be at least sizing.hard_min_unflex (%g)",
+			fraction(g.softMinUnflex), fraction(g.hardMinUnflex))
+	case g.maxFlex < g.softMinFlex:
+		return fmt.Errorf("sizing.max_flex (%g) must be at least sizing.soft_min_flex (%g)",
+			fraction(g.maxFlex), fraction(g.softMinFlex))
+	case g.maxFlex+g.hardMinUnflex > normal:
+		return fmt.Errorf("sizing.max_flex (%g) leaves no room for an unflexed item of sizing.hard_min_unflex (%g)",
+			fraction(g.maxFlex), fraction(g.hardMinUnflex))
+	case g.softMinFlex+g.softMinUnflex > normal:
+		return fmt.Errorf("sizing.soft_min_flex (%g) and sizing.soft_min_unflex (%g) can't both fit in a container",
+			fraction(g.softMinFlex), fraction(g.softMinUnflex))
+	case defaultFlex < g.softMinFlex || defaultFlex > g.maxFlex:
+		// Flexing never goes below the soft minimum, so a smaller ratio would be silently ignored
+		return fmt.Errorf("sizing.default_flex_ratio (%g) must be between sizing.soft_min_flex (%g) and sizing.max_flex (%g)",
+			fraction(defaultFlex), fraction(g.softMinFlex), fraction(g.maxFlex))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.i3wm.org/i3/v4"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "i3-flex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	path := writeConfig(t, "[sizing]\nmax_flex = 0.8\nsoft_min_unflex = 0.15\n")
+	config, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.sizings.maxFlex != 800 || config.sizings.softMinUnflex != 150 {
+		t.Errorf("overrides not applied: %+v", config.sizings)
+	}
+	if config.sizings.hardMinUnflex != defaultGlobalSizings().hardMinUnflex {
+		t.Errorf("unset value should keep its default: %+v", config.sizings)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, contents := range []string{
+		"[sizing]\nhard_min_flex = 0.5\n",
+		"[sizing]\nsoft_min_unflex = 0.01\n",
+		"[sizing]\nmax_flex = 0.99\n",
+		"[sizing]\nmax_flex = 1.5\n",
+		"[sizing]\nmax_flx = 0.8\n",
+		"[sizing]\ndefault_flex_ratio = 0.55\n",
+		"[[rules]]\nsoft_min_flex = 0.7\n",
+		"[[rules]]\nclass = \"(\"\nsoft_min_flex = 0.7\n",
+		"[[rules]]\nclass = \"x\"\nsoft_min_unflex = 0.01\n",
+		"[[rules]]\nclass = \"x\"\nflex_ratio = 0.55\n",
+		"[log]\nlevel = \"loud\"\n",
+		"[log.categories]\nwindows = \"debug\"\n",
+	} {
+		if _, err := loadConfig(writeConfig(t, contents), true); err == nil {
+			t.Errorf("expected an error for %q", contents)
+		}
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	if _, err := loadConfig("/nonexistent/config.toml", false); err != nil {
+		t.Errorf("missing optional config should use defaults: %s", err)
+	}
+	if _, err := loadConfig("/nonexistent/config.toml", true); err == nil {
+		t.Error("missing required config should fail")
+	}
+}
+
+func TestLoadConfigRules(t *testing.T) {
+	path := writeConfig(t, `
+[[rules]]
+class = "^Firefox$"
+soft_min_flex = 0.7
+
+[[rules]]
+class = "^Firefox$"
+title = "Private"
+flex_ratio = 0.8
+`)
+	config, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := &FlexItem{id: 1, window: 1}
+	applyRules(config.rules, item, i3.WindowProperties{Class: "Firefox", Title: "Private Browsing"})
+	if item.softMinFlex != 700 || item.flexTarget != 800 {
+		t.Errorf("expected both rules to apply: %+v", item)
+	}
+
+	item = &FlexItem{id: 2, window: 2}
+	applyRules(config.rules, item, i3.WindowProperties{Class: "firefox", Title: "Private"})
+	if item.softMinFlex != 0 || item.flexTarget != 0 {
+		t.Errorf("expected no rules to apply: %+v", item)
+	}
+}
+
+func TestLoadConfigAreas(t *testing.T) {
+	path := writeConfig(t, `
+[areas]
+disabled_workspaces = ["^media$"]
+enabled_outputs = ["^eDP"]
+`)
+	config, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	areas := config.areas
+	if !areas.allows("1", "eDP-1") {
+		t.Error("expected workspace 1 on eDP-1 to be flexed")
+	}
+	if areas.allows("media", "eDP-1") {
+		t.Error("expected the media workspace not to be flexed")
+	}
+	if areas.allows("1", "HDMI-1") {
+		t.Error("expected outputs besides eDP not to be flexed")
+	}
+	areas.set(outputArea, "HDMI-1", true)
+	if !areas.allows("1", "HDMI-1") {
+		t.Error("expected the runtime override to enable HDMI-1")
+	}
+}
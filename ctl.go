@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// The control protocol is one JSON request per connection, answered by one JSON response.
+type ctlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+type ctlResponse struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Handles a control command while holding the daemon lock.
+// The result, if not nil, is sent back to the client as JSON.
+type ctlHandler func(d *daemon, args []string) (interface{}, error)
+
+var ctlHandlers = map[string]ctlHandler{
+	"state":   ctlState,
+	"flex":    ctlFlex,
+	"reset":   ctlReset,
+	"resync":  ctlResync,
+	"pause":   ctlPause,
+	"resume":  ctlResume,
+	"pin":     ctlPin,
+	"unpin":   ctlUnpin,
+	"enable":  ctlEnable,
+	"disable": ctlDisable,
+	"sizing":  ctlSizing,
+}
+
+func ctlState(d *daemon, args []string) (interface{}, error) {
+	return d.fm.State(), nil
+}
+
+func ctlFlex(d *daemon, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: flex <con_id>")
+	}
+	id, err := parseNodeID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	if !d.fm.OnFocus(id) {
+		return nil, fmt.Errorf("con_id %d is not in any flex model", id)
+	}
+	return nil, nil
+}
+
+func ctlPin(d *daemon, args []string) (interface{}, error) {
+	id, err := d.targetID("pin", args)
+	if err != nil {
+		return nil, err
+	}
+	if !d.fm.Pin(id) {
+		return nil, fmt.Errorf("con_id %d is not in any flex model", id)
+	}
+	return nil, nil
+}
+
+func ctlUnpin(d *daemon, args []string) (interface{}, error) {
+	id, err := d.targetID("unpin", args)
+	if err != nil {
+		return nil, err
+	}
+	if !d.fm.Unpin(id) {
+		return nil, fmt.Errorf("con_id %d is not in any flex model", id)
+	}
+	return nil, nil
+}
+
+// Syncs, and returns the con_id given in args, or the focused container if there is none,
+// so commands can be bound to keys without knowing the id.
+func (d *daemon) targetID(command string, args []string) (i3.NodeID, error) {
+	if len(args) > 1 {
+		return 0, fmt.Errorf("usage: %s [con_id]", command)
+	}
+	root, err := d.syncTree()
+	if err != nil {
+		return 0, err
+	}
+	if len(args) == 1 {
+		return parseNodeID(args[0])
+	}
+	focused := findFocused(root)
+	if focused == nil {
+		return 0, errors.New("no container is focused")
+	}
+	return focused.ID, nil
+}
+
+func ctlEnable(d *daemon, args []string) (interface{}, error) {
+	return nil, d.setArea("enable", args, true)
+}
+
+func ctlDisable(d *daemon, args []string) (interface{}, error) {
+	return nil, d.setArea("disable", args, false)
+}
+
+// Turns flexing on or off for the workspace or output named in args,
+// defaulting to the one with the focused container.
+func (d *daemon) setArea(command string, args []string, enabled bool) error {
+	if len(args) < 1 || len(args) > 2 || (args[0] != string(workspaceArea) && args[0] != string(outputArea)) {
+		return fmt.Errorf("usage: %s workspace|output [name]", command)
+	}
+	kind := areaKind(args[0])
+	var name string
+	if len(args) == 2 {
+		name = args[1]
+	} else {
+		tree, err := i3.GetTree()
+		if err != nil {
+			return err
+		}
+		focused := findFocused(tree.Root)
+		if focused == nil {
+			return errors.New("no container is focused")
+		}
+		workspace, output := locate(findPath(tree.Root, focused.ID))
+		name = workspace
+		if kind == outputArea {
+			name = output
+		}
+	}
+	logInfo(logEvents, "Flexing %sd for %s [%s]", command, kind, name)
+	d.fm.areas.set(kind, name, enabled)
+	return d.sync()
+}
+
+// Sets the sizing strategy of a container's model, "global" reverting it to the one from the config
+func ctlSizing(d *daemon, args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errors.New("usage: sizing <strategy>|global [con_id]")
+	}
+	var sizing Sizing
+	if args[0] != "global" {
+		var err error
+		if sizing, err = lookupSizing(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	id, err := d.targetID("sizing <strategy>|global", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if !d.fm.SetSizing(id, sizing) {
+		return nil, fmt.Errorf("con_id %d is not in any flex model", id)
+	}
+	return nil, nil
+}
+
+func ctlReset(d *daemon, args []string) (interface{}, error) {
+	d.reloadGaps()
+	d.fm.Reset()
+	return nil, d.sync()
+}
+
+func ctlResync(d *daemon, args []string) (interface{}, error) {
+	return nil, d.sync()
+}
+
+func ctlPause(d *daemon, args []string) (interface{}, error) {
+	d.paused = true
+	return nil, nil
+}
+
+func ctlResume(d *daemon, args []string) (interface{}, error) {
+	d.paused = false
+	return nil, d.sync()
+}
+
+func parseNodeID(s string) (i3.NodeID, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid con_id %q", s)
+	}
+	return i3.NodeID(id), nil
+}
+
+// Returns the path of the control socket.
+//
+// It lives next to i3's own IPC socket, so each i3 session gets its own daemon.
+// Without an i3 socket it falls back to one per user and DISPLAY.
+// I3_FLEX_SOCK overrides both.
+func ctlSocketPath() string {
+	if path := os.Getenv("I3_FLEX_SOCK"); path != "" {
+		return path
+	}
+	i3Path, err := i3.SocketPathHook()
+	if err == nil && i3Path != "" {
+		return filepath.Join(filepath.Dir(i3Path), "i3-flex."+filepath.Base(i3Path))
+	}
+	display := strings.Replace(os.Getenv("DISPLAY"), "/", "_", -1)
+	return filepath.Join(os.TempDir(), fmt.Sprintf("i3-flex.%d%s.sock", os.Getuid(), display))
+}
+
+// Listens on the control socket, replacing it if it's left over from a dead daemon.
+func listenCtl(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is already listening on %s", path)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// Accepts control connections until the listener is closed
+func (d *daemon) serveCtl(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logWarn(logEvents, "Stopped accepting control connections: %s", err.Error())
+			return
+		}
+		go d.handleCtl(conn)
+	}
+}
+
+func (d *daemon) handleCtl(conn net.Conn) {
+	defer conn.Close()
+
+	var req ctlRequest
+	var resp ctlResponse
+	err := json.NewDecoder(conn).Decode(&req)
+	if err == nil && req.Command == watchCommand {
+		d.watch(conn)
+		return
+	}
+	if err == nil {
+		var result interface{}
+		result, err = d.ctl(req)
+		if err == nil && result != nil {
+			resp.Result, err = json.Marshal(result)
+		}
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		logWarn(logEvents, "Error writing control response: %s", err.Error())
+	}
+}
+
+func (d *daemon) ctl(req ctlRequest) (result interface{}, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.changed()
+	defer func() {
+		if r := recover(); r != nil {
+			d.recovered("control command "+req.Command, r)
+			result, err = nil, fmt.Errorf("%s failed: %v", req.Command, r)
+		}
+	}()
+	return d.dispatch(req)
+}
+
+// Runs a control command. Must be called holding d.mu.
+func (d *daemon) dispatch(req ctlRequest) (interface{}, error) {
+	handler, ok := ctlHandlers[req.Command]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", req.Command)
+	}
+	logInfo(logEvents, "Control command %s %v", req.Command, req.Args)
+	return handler(d, req.Args)
+}
+
+func dialCtl() (net.Conn, error) {
+	path := ctlSocketPath()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the daemon at %s: %w", path, err)
+	}
+	return conn, nil
+}
+
+// Sends a single request to the running daemon
+func sendCtl(req ctlRequest) (json.RawMessage, error) {
+	conn, err := dialCtl()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp ctlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Result, nil
+}
+
+func ctlCommands() string {
+	commands := make([]string, 0, len(ctlHandlers))
+	for command := range ctlHandlers {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return strings.Join(commands, ", ")
+}
+
+// The `i3-flex ctl` client
+func runCtl(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: ctl <command> [args...]. Commands: %s", ctlCommands())
+	}
+	result, err := sendCtl(ctlRequest{Command: args[0], Args: args[1:]})
+	if err != nil {
+		return err
+	}
+	if len(result) > 0 {
+		var out interface{}
+		if err := json.Unmarshal(result, &out); err != nil {
+			return err
+		}
+		pretty, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(pretty))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// The running state of `i3-flex serve`.
+//
+// Both the i3 event loop and the control socket drive the same FlexModels,
+// so everything touching them must hold mu.
+type daemon struct {
+	mu     sync.Mutex
+	fm     *FlexModels
+	paused bool // Set by `ctl pause`. Events are ignored until resumed
+
+	resizeMode bool // i3 is in resize mode. Flexing is held off until the user is done
+	restarting bool // i3 is restarting, so con ids will change and models need restoring
+	exiting    bool // i3 is exiting, and so should the daemon
+
+	gaps    i3Gaps                  // From the i3 config, to size items from the space they really get
+	getTree func() (i3.Tree, error) // Gets the layout from i3, replaced in tests
+
+	statePath  string // Where models are persisted. Empty disables persistence
+	savedState []byte // What was last written to statePath
+
+	watchers map[chan []modelView]bool // Clients of `watch`
+}
+
+func newDaemon(fm *FlexModels, statePath string) *daemon {
+	return &daemon{
+		fm:        fm,
+		getTree:   i3.GetTree,
+		statePath: statePath,
+		watchers:  make(map[chan []modelView]bool),
+	}
+}
+
+// Called after anything which may have changed the models. Must be called holding d.mu.
+func (d *daemon) changed() {
+	d.persist()
+	d.publish()
+}
+
+// Brings all models up to date with the current i3 tree.
+func (d *daemon) sync() error {
+	_, err := d.syncTree()
+	return err
+}
+
+// Like sync, but also returns the root of the tree it synced with
+func (d *daemon) syncTree() (*i3.Node, error) {
+	d.fm.Settle()
+	tree, err := d.getTree()
+	if err != nil {
+		return nil, err
+	}
+	t := createTraverser(tree.Root)
+	updates := fullUpdate(t, d.gaps)
+	d.fm.Updates(updates, true)
+	return tree.Root, nil
+}
+
+// Brings only the models affected by an event for the container up to date:
+// those on its path from the root, and those it was an item of before the event,
+// which differ when it was moved or closed.
+func (d *daemon) syncContainer(id i3.NodeID) error {
+	previous := d.fm.modelsContaining(id)
+	d.fm.Settle()
+	tree, err := d.getTree()
+	if err != nil {
+		return err
+	}
+	d.fm.Updates(d.containerUpdates(tree.Root, id, previous), false)
+	return nil
+}
+
+// Returns the updates for the models on the container's path, and for the previous models,
+// dropping those which aren't split containers anymore.
+// i3 removes a split along with its last window, so the models which had a dropped one
+// as an item are brought up to date too, rather than keeping the dead container until the next full sync.
+func (d *daemon) containerUpdates(root *i3.Node, id i3.NodeID, previous []*FlexModel) []FlexUpdate {
+	updates := pathUpdates(root, id, d.gaps)
+	dropped := make(map[i3.NodeID]bool)
+	for i := 0; i < len(previous); i++ {
+		model := previous[i]
+		if containsUpdate(updates, model.id) || dropped[model.id] {
+			continue
+		}
+		if path := findPath(root, model.id); path != nil {
+			if update, ok := containerUpdate(path, d.gaps); ok {
+				updates = append(updates, update)
+				continue
+			}
+		}
+		d.fm.Remove(model.id)
+		dropped[model.id] = true
+		previous = append(previous, d.fm.modelsContaining(model.id)...)
+	}
+	return updates
+}
+
+func containsUpdate(updates []FlexUpdate, id i3.NodeID) bool {
+	for _, update := range updates {
+		if update.ExternalId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Periodically does a full sync, to catch anything the incremental updates missed
+func (d *daemon) resyncEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		d.resync()
+	}
+}
+
+func (d *daemon) resync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			d.recovered("resync", r)
+		}
+	}()
+	if !d.active() {
+		return
+	}
+	if err := d.sync(); err != nil {
+		logError(logEvents, "Error resyncing: %s", err.Error())
+	}
+	d.changed()
+}
+
+// Reads the gaps from the i3 config, which may have changed with a reload or restart
+func (d *daemon) reloadGaps() {
+	gaps, err := loadGaps()
+	if err != nil {
+		logWarn(logTraverser, "Error reading gaps from the i3 config: %s", err.Error())
+		return
+	}
+	d.gaps = gaps
+}
+
+// Whether layout changing events should be acted on
+func (d *daemon) active() bool {
+	return !d.paused && !d.resizeMode
+}
+
+// Saves the state one last time before the daemon exits.
+func (d *daemon) shutdown() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fm.Settle()
+	d.persist()
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSyncContainerDropsRemovedSplits(t *testing.T) {
+	m := loadTestMock(t)
+	d := newDaemon(initFlexModels(defaultGlobalSizings()), "")
+	d.fm.Updates(fullUpdate(createTraverser(m.tree), d.gaps), true)
+	if model, _ := d.fm.findItem(6); model == nil {
+		t.Fatal("expected the split to be an item of the workspace model")
+	}
+
+	// Closing the terminal and the browser removes their split
+	previous := d.fm.modelsContaining(7)
+	workspace := findPath(m.tree, 4)
+	ws := workspace[len(workspace)-1]
+	ws.Nodes = ws.Nodes[:1]
+	d.fm.Updates(d.containerUpdates(m.tree, 7, previous), false)
+
+	if model, _ := d.fm.findItem(6); model != nil {
+		t.Errorf("expected the removed split to be dropped from model [%d]", model.id)
+	}
+}
+
+func TestCtlRecoversFromPanics(t *testing.T) {
+	ctlHandlers["panic"] = func(d *daemon, args []string) (interface{}, error) { panic("boom") }
+	defer delete(ctlHandlers, "panic")
+	d := newDaemon(initFlexModels(defaultGlobalSizings()), "")
+	if _, err := d.ctl(ctlRequest{Command: "panic"}); err == nil {
+		t.Error("expected the panic to be returned as an error")
+	}
+}
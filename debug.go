@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// A model as shown by `debug` and `watch`: its state, plus what's needed to make sense of the sizes
+type modelView struct {
+	FlexModelState
+	Ppt         []int    `json:"ppt"`      // The percents the items render as, in the same order as Items
+	Minimums    []Size   `json:"minimums"` // The minimums in force for the items
+	Invalidated []string `json:"invalidated,omitempty"`
+}
+
+func newModelView(model *FlexModel) modelView {
+	minimums := make([]Size, 0, len(model.items))
+	for i := range model.items {
+		minimums = append(minimums, model.GetMin(i))
+	}
+	return modelView{
+		FlexModelState: model.State(),
+		Ppt:            itemPpts(model),
+		Minimums:       minimums,
+		Invalidated:    model.invalidated,
+	}
+}
+
+// Views of all models, ordered by id like FlexModels.State
+func (f *FlexModels) views() []modelView {
+	views := make([]modelView, 0, len(f.models))
+	for _, state := range f.State() {
+		views = append(views, newModelView(f.models[state.ID]))
+	}
+	return views
+}
+
+// Builds the models from the current tree, the same way the daemon does, and prints them.
+func runDebug(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	configPath := flags.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	statePath := flags.String("state", "", "path to a state file to restore into the models, e.g. "+defaultStatePath())
+	asJSON := flags.Bool("json", false, "print the models as JSON")
+	verbose := flags.Bool("v", false, "log what the models are doing to stderr")
+	logSpec := flags.String("log", "", "log levels with -v, overriding the config: a level, category=level, or a comma separated mix")
+	flags.Parse(args)
+
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	config, err := loadConfigFlag(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := config.useLogLevels(*logSpec); err != nil {
+		return err
+	}
+	gaps, err := loadGaps()
+	if err != nil {
+		return err
+	}
+	tree, err := i3.GetTree()
+	if err != nil {
+		return err
+	}
+
+	fm := initFlexModels(config.sizings)
+	fm.SetRules(config.rules)
+	fm.SetAreas(config.areas)
+	fm.SetPropagation(config.propagation)
+	fm.Updates(fullUpdate(createTraverser(tree.Root), gaps), true)
+	if *statePath != "" {
+		states, err := loadState(*statePath)
+		if err != nil {
+			return err
+		}
+		fm.Restore(states)
+	}
+
+	models := fm.views()
+	if *asJSON {
+		out, err := json.MarshalIndent(models, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	simplePrint(createTraverser(tree.Root))
+	fmt.Println()
+	printModels(os.Stdout, models)
+	return nil
+}
+
+// Returns the percent each item renders as, in item order
+func itemPpts(model *FlexModel) []int {
+	byCurrent, ppts := pptsByCurrent(model)
+	pptOf := make(map[*FlexItem]int, len(byCurrent))
+	for i, item := range byCurrent {
+		pptOf[item] = ppts[i]
+	}
+	inOrder := make([]int, 0, len(model.items))
+	for _, item := range model.items {
+		inOrder = append(inOrder, pptOf[item])
+	}
+	return inOrder
+}
+
+func printModels(out io.Writer, models []modelView) {
+	for _, model := range models {
+		fmt.Fprintf(out, "model [%d] %s", model.ID, model.Direction)
+		if model.Sizing != "" {
+			fmt.Fprintf(out, " sizing=%s", model.Sizing)
+		}
+		fmt.Fprintln(out)
+		for i, item := range model.Items {
+			flags := make([]string, 0)
+			if item.Flexed {
+				flags = append(flags, "flexed")
+			}
+			if item.Pinned {
+				flags = append(flags, "pinned")
+			}
+			if item.Window != 0 {
+				flags = append(flags, fmt.Sprintf("window=%d", item.Window))
+			}
+			if item.SoftMinFlex > 0 {
+				flags = append(flags, fmt.Sprintf("soft_min_flex=%d", item.SoftMinFlex))
+			}
+			if item.SoftMinUnflex > 0 {
+				flags = append(flags, fmt.Sprintf("soft_min_unflex=%d", item.SoftMinUnflex))
+			}
+			if item.FlexTarget > 0 {
+				flags = append(flags, fmt.Sprintf("flex_target=%d", item.FlexTarget))
+			}
+			flags = append(flags, fmt.Sprintf("min=%d", model.Minimums[i]))
+			fmt.Fprintf(out, "  %d: [%d] %4d (%3d ppt) %s\n", i, item.ID, item.Current, model.Ppt[i], strings.Join(flags, " "))
+		}
+		if len(model.Constraints) > 0 {
+			constraints := make([]string, 0, len(model.Constraints))
+			for _, c := range model.Constraints {
+				constraints = append(constraints, fmt.Sprintf("%d:%s", c.Item, c.Kind))
+			}
+			// The first constraint is the first to be invalidated
+			fmt.Fprintf(out, "  constraints: %s\n", strings.Join(constraints, " "))
+		}
+	}
+}
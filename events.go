@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// Every event type the daemon reacts to
+var subscribedEvents = []i3.EventType{
+	i3.WindowEventType,
+	i3.WorkspaceEventType,
+	i3.OutputEventType,
+	i3.ModeEventType,
+	i3.BindingEventType,
+	i3.TickEventType,
+	i3.ShutdownEventType,
+}
+
+// Bindings running `nop i3-flex <command> [args...]`, and ticks sent with an
+// `i3-flex <command> [args...]` payload, run control commands without going through `ctl`.
+const commandPrefix = "i3-flex "
+
+// Handles an i3 event. Returns false once the daemon should stop.
+func (d *daemon) onEvent(event i3.Event) (proceed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.recoverFrom(event, &proceed)
+
+	var err error
+	switch ev := event.(type) {
+	case *i3.WindowEvent:
+		err = d.onWindowEvent(ev)
+	case *i3.WorkspaceEvent:
+		err = d.onWorkspaceEvent(ev)
+	case *i3.OutputEvent:
+		err = d.onOutputEvent(ev)
+	case *i3.ModeEvent:
+		err = d.onModeEvent(ev)
+	case *i3.BindingEvent:
+		err = d.onBindingEvent(ev)
+	case *i3.TickEvent:
+		err = d.onTickEvent(ev)
+	case *i3.ShutdownEvent:
+		err = d.onShutdownEvent(ev)
+	default:
+		logWarn(logEvents, "Unexpected event type: %+v", event)
+	}
+	if err != nil {
+		logError(logEvents, "Error handling %T: %s", event, err.Error())
+	}
+	d.changed()
+	return !d.exiting
+}
+
+func (d *daemon) onWindowEvent(ev *i3.WindowEvent) error {
+	if !d.active() {
+		return nil
+	}
+
+	logDebug(logEvents, "Window %s [%d]", ev.Change, ev.Container.ID)
+	// Do updates
+	err := d.syncContainer(ev.Container.ID)
+	if err != nil {
+		return err
+	}
+	if target, ok := focusedIn([]*i3.WindowEvent{ev}); ok {
+		d.fm.OnFocus(target)
+	}
+	return nil
+}
+
+// Recovers from a panic handling events, so one bad event can't take the daemon down.
+// The models may have been left half way through a change, so they're all dropped, to be rebuilt from the tree.
+// Must be deferred holding d.mu.
+func (d *daemon) recoverFrom(event interface{}, proceed *bool) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	d.recovered(fmt.Sprintf("%T", event), r)
+	*proceed = !d.exiting
+}
+
+// Drops all models after recovering from a panic during what, to be rebuilt from the tree.
+// Must be called holding d.mu, from the deferred function which recovered.
+func (d *daemon) recovered(what string, r interface{}) {
+	logError(logEvents, "Recovered from panic handling %s: %v\n%s", what, r, debug.Stack())
+	d.fm.Reset()
+	d.publish()
+}
+
+// Switching workspaces doesn't necessarily focus a different window of the workspace,
+// so flex whatever window has the focus there.
+func (d *daemon) onWorkspaceEvent(ev *i3.WorkspaceEvent) error {
+	if ev.Change == "reload" {
+		// The gaps may have changed along with the rest of the i3 config
+		d.reloadGaps()
+	}
+	if !d.active() || ev.Change != "focus" {
+		return nil
+	}
+	root, err := d.syncTree()
+	if err != nil {
+		return err
+	}
+	if focused := findFocused(root); focused != nil {
+		d.fm.OnFocus(focused.ID)
+	}
+	return nil
+}
+
+// Outputs were added, removed or changed, which may move workspaces around
+func (d *daemon) onOutputEvent(ev *i3.OutputEvent) error {
+	if !d.active() {
+		return nil
+	}
+	return d.sync()
+}
+
+// Flexing in the middle of resize mode would fight the user.
+// Once they leave it, sync so their resizes are learned.
+func (d *daemon) onModeEvent(ev *i3.ModeEvent) error {
+	wasResizing := d.resizeMode
+	d.resizeMode = ev.Change == "resize"
+	if wasResizing && !d.resizeMode && !d.paused {
+		return d.sync()
+	}
+	return nil
+}
+
+func (d *daemon) onBindingEvent(ev *i3.BindingEvent) error {
+	if ev.Change != "run" {
+		return nil
+	}
+	for _, cmd := range strings.FieldsFunc(ev.Binding.Command, func(r rune) bool { return r == ';' || r == ',' }) {
+		cmd = strings.TrimSpace(cmd)
+		if strings.HasPrefix(cmd, "nop "+commandPrefix) {
+			if err := d.runCommand(strings.TrimPrefix(cmd, "nop ")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The first tick is sent when subscribing, which after an i3 restart means we're reconnected
+func (d *daemon) onTickEvent(ev *i3.TickEvent) error {
+	if ev.First {
+		if !d.restarting {
+			return nil
+		}
+		d.restarting = false
+		d.reloadGaps()
+		d.fm.Reset()
+		if err := d.sync(); err != nil {
+			return err
+		}
+		return d.restore()
+	}
+	if strings.HasPrefix(ev.Payload, commandPrefix) {
+		return d.runCommand(ev.Payload)
+	}
+	return nil
+}
+
+func (d *daemon) onShutdownEvent(ev *i3.ShutdownEvent) error {
+	logInfo(logEvents, "i3 is shutting down (%s)", ev.Change)
+	// Save now, since ids are about to become meaningless
+	d.persist()
+	if ev.Change == "restart" {
+		d.restarting = true
+	} else {
+		d.exiting = true
+	}
+	return nil
+}
+
+// Runs an `i3-flex <command> [args...]` control command from a binding or tick
+func (d *daemon) runCommand(cmd string) error {
+	words := strings.Fields(strings.TrimPrefix(cmd, commandPrefix))
+	if len(words) == 0 {
+		return nil
+	}
+	result, err := d.dispatch(ctlRequest{Command: words[0], Args: words[1:]})
+	if result != nil {
+		logDebug(logEvents, "Result of %s: %+v", cmd, result)
+	}
+	return err
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"fmt"
 
 	"go.i3wm.org/i3/v4"
 )
@@ -11,6 +11,7 @@ func isFlexed(size Size) bool {
 }
 
 type MinConstraint interface {
+	fmt.Stringer
 	Invalidate()
 }
 
@@ -22,22 +23,25 @@ type MinItemConstraint interface {
 type MinConstraintChain struct {
 	userDefined []MinItemConstraint
 	global      []MinConstraint
+	invalidated []MinConstraint
 }
 
 // Invalidates the first available constraint
 func (chain *MinConstraintChain) Invalidate() bool {
 	if len(chain.userDefined) > 0 {
-		log.Printf("Invalidating user defined minimum")
+		logDebug(logModel, "Invalidating %s", chain.userDefined[0])
 		chain.userDefined[0].Invalidate()
+		chain.invalidated = append(chain.invalidated, chain.userDefined[0])
 		chain.userDefined = chain.userDefined[1:]
 		return true
 	} else if len(chain.global) > 0 {
-		log.Printf("Invalidating global minimum")
+		logDebug(logModel, "Invalidating %s", chain.global[0])
 		chain.global[0].Invalidate()
+		chain.invalidated = append(chain.invalidated, chain.global[0])
 		chain.global = chain.global[1:]
 		return true
 	} else {
-		log.Printf("Chain exhausted. Hard limits observed.")
+		logDebug(logModel, "Chain exhausted. Hard limits observed.")
 		return false
 	}
 }
@@ -49,6 +53,9 @@ type FlexItemMinFlexConstraint struct {
 
 func (c FlexItemMinFlexConstraint) ItemIndex() int { return c.idx }
 func (c FlexItemMinFlexConstraint) Invalidate()    { c.FlexItem.softMinFlex = -1 }
+func (c FlexItemMinFlexConstraint) String() string {
+	return fmt.Sprintf("[%d] soft_min_flex", c.FlexItem.id)
+}
 
 type FlexItemMinUnflexConstraint struct {
 	*FlexItem
@@ -57,14 +64,19 @@ type FlexItemMinUnflexConstraint struct {
 
 func (c FlexItemMinUnflexConstraint) ItemIndex() int { return c.idx }
 func (c FlexItemMinUnflexConstraint) Invalidate()    { c.FlexItem.softMinUnflex = -1 }
+func (c FlexItemMinUnflexConstraint) String() string {
+	return fmt.Sprintf("[%d] soft_min_unflex", c.FlexItem.id)
+}
 
 type GlobalSoftMinUnflexConstraint struct{ *FlexModel }
 
-func (c GlobalSoftMinUnflexConstraint) Invalidate() { c.FlexModel.globalSoftMinUnflexObserved = false }
+func (c GlobalSoftMinUnflexConstraint) Invalidate()    { c.FlexModel.globalSoftMinUnflexObserved = false }
+func (c GlobalSoftMinUnflexConstraint) String() string { return "global soft_min_unflex" }
 
 type GlobalSoftMinFlexConstraint struct{ *FlexModel }
 
-func (c GlobalSoftMinFlexConstraint) Invalidate() { c.FlexModel.globalSoftMinFlexObserved = false }
+func (c GlobalSoftMinFlexConstraint) Invalidate()    { c.FlexModel.globalSoftMinFlexObserved = false }
+func (c GlobalSoftMinFlexConstraint) String() string { return "global soft_min_flex" }
 
 // The direction in which the items in a model are resized
 type FlexDirection string
@@ -80,9 +92,13 @@ type FlexModel struct {
 	items       []*FlexItem
 	constraints []MinItemConstraint // User defined constraints
 	direction   FlexDirection
+	sizing      Sizing // Overrides globals.strategy for this model, if not nil
+	recent      []int  // Indexes of the items which were flexed, most recent first
 
 	globalSoftMinUnflexObserved bool
 	globalSoftMinFlexObserved   bool
+
+	invalidated []string // The constraints invalidated by the last reduction loop, for watching
 }
 
 type FlexEvent struct {
@@ -93,10 +109,12 @@ type FlexEvent struct {
 // Called in response to user initiated resizing.
 // The user may have increased the size of an item without flexing it
 // Or the user may have manually flexed an item.
+// Or the user may have shrunk an item, which should then stay that small.
 // If the user also focused a new window, that will be handled later in OnFocus.
-func (f *FlexModel) OnUpdate(events []FlexEvent) {
+func (f *FlexModel) OnUpdate(events []FlexEvent) error {
 	// First update those items, corresponding to those IDs,
-	// and track the amount that will need to be reduced from other items
+	// and track the amount that will need to be reduced from other items,
+	// or that was freed up for them
 
 	delta := Size(0)
 	excludeIndexes := make([]int, 0, len(events))
@@ -105,6 +123,13 @@ func (f *FlexModel) OnUpdate(events []FlexEvent) {
 			if item.id == ev.id {
 				item.current = item.current + ev.increase
 				delta = delta - ev.increase
+				if ev.increase > 0 {
+					item.shrunk = false
+					// Growth beyond maxFlex isn't kept, so there's less to reduce
+					delta = delta + f.capFlex(k)
+				} else {
+					f.lowerMin(k)
+				}
 				excludeIndexes = append(excludeIndexes, k)
 				f.putConstraint(k) // create or bump a min constraint based on whether it's currently flexed
 				break
@@ -112,22 +137,166 @@ func (f *FlexModel) OnUpdate(events []FlexEvent) {
 		}
 	}
 
-	// Do the reduction loop
-	f.reductionLoop(delta, excludeIndexes, 0)
+	// An item the user grew into being flexed takes over from the one that was.
+	// Otherwise both would keep a flexed minimum, which can't fit.
+	compl := f.complement(excludeIndexes)
+	for _, k := range excludeIndexes {
+		if !isFlexed(f.items[k].current) {
+			continue
+		}
+		for _, i := range compl {
+			if item := f.items[i]; isFlexed(item.current) {
+				min := f.unflexTarget(i)
+				logDebug(logModel, "Unflexing [%d] to %d, since [%d] was flexed by the user", item.id, min, f.items[k].id)
+				delta = delta + item.current - min
+				item.current = min
+			}
+		}
+		break
+	}
+
+	if delta > 0 {
+		// Items were shrunk, or the grown items were capped below their previous size: give the surplus to the others
+		return f.distribute(compl, delta)
+	} else if delta < 0 {
+		// Do the reduction loop
+		return f.reductionLoop(delta, excludeIndexes, 0)
+	}
+	return nil
+}
+
+// Keeps the item at the size the user shrunk it to, so it isn't grown back.
+// A flexed item gets flexed to it from now on, if it's below its flex target.
+// An unflexed item gets it as its soft minimum, and is held there rather than grown with the others.
+func (f *FlexModel) lowerMin(idx int) {
+	item := f.items[idx]
+	if isFlexed(item.current) {
+		if min := maxSize(item.current, f.globals.hardMinFlex); min < f.flexTarget(idx) {
+			logInfo(logModel, "Lowering soft min flex of [%d] to %d", item.id, min)
+			item.softMinFlex = min
+			item.flexTarget = min
+		}
+		return
+	}
+	min := maxSize(item.current, f.globals.hardMinUnflex)
+	logInfo(logModel, "Holding [%d] at soft min unflex %d", item.id, min)
+	item.softMinUnflex = min
+	item.shrunk = true
+}
+
+// Hands space freed up by shrinking items to the items at indexes, according to the shrink policy
+func (f *FlexModel) distribute(indexes []int, delta Size) error {
+	if len(indexes) == 0 {
+		// Every item was resized. Whatever's left goes to the unflexed ones
+		indexes = f.unflexed()
+	}
+	switch f.globals.shrinkPolicy {
+	case flexedShrinkPolicy:
+		for _, i := range indexes {
+			item := f.items[i]
+			if isFlexed(item.current) && item.current < f.globals.maxFlex {
+				give := minSize(delta, f.globals.maxFlex-item.current)
+				item.current = item.current + give
+				delta = delta - give
+			}
+		}
+	case unflexedShrinkPolicy:
+		unflexed := make([]int, 0, len(indexes))
+		for _, i := range indexes {
+			if !isFlexed(f.items[i].current) {
+				unflexed = append(unflexed, i)
+			}
+		}
+		if len(unflexed) > 0 {
+			indexes = unflexed
+		}
+	}
+	if delta > 0 && len(indexes) > 0 {
+		return f.grow(indexes, delta)
+	}
+	return nil
+}
+
+// Grows the items at indexes by delta, in proportion to their sizes.
+// Items the user shrunk stay at their size, unless there's nothing else to grow.
+func (f *FlexModel) grow(indexes []int, delta Size) error {
+	growable := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		if item := f.items[i]; !item.shrunk || item.softMinUnflex <= 0 || isFlexed(item.current) {
+			growable = append(growable, i)
+		}
+	}
+	if len(growable) == 0 {
+		growable = indexes
+	}
+	_, err := rebalance(f.sizes(growable), f.minimums(growable), delta)
+	return err
+}
+
+func minSize(a, b Size) Size {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxSize(a, b Size) Size {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Shrinks the item down to maxFlex if it's larger, returning the surplus.
+// A lone item always takes up the whole container.
+func (f *FlexModel) capFlex(idx int) Size {
+	item := f.items[idx]
+	if len(f.items) < 2 || item.current <= f.globals.maxFlex {
+		return 0
+	}
+	surplus := item.current - f.globals.maxFlex
+	logDebug(logModel, "Capping item [%d] at max flex %d, surplus %d", item.id, f.globals.maxFlex, surplus)
+	item.current = f.globals.maxFlex
+	return surplus
+}
+
+// Returns the size the item should be flexed to:
+// its own flex target if it has one, otherwise the default flex ratio.
+// It's kept between the item's soft minimum for flexing and maxFlex.
+func (f *FlexModel) flexTarget(idx int) Size {
+	item := f.items[idx]
+	target := Size(f.globals.defaultFlexRatio.Normalize())
+	if item.flexTarget > 0 {
+		target = item.flexTarget
+	}
+	min := f.globals.softMinFlex
+	if item.softMinFlex > 0 {
+		min = item.softMinFlex
+	}
+	if target < min {
+		target = min
+	}
+	if target > f.globals.maxFlex {
+		target = f.globals.maxFlex
+	}
+	return target
 }
 
 // Performs the reduction loop, invalidating constraints as needed to make the
 // necessary reductions incurred by the grown items (denoted by excludeIndexes).
 //
 // FlexItem.current should already be updated for excludeIndexes before running the loop
-func (f *FlexModel) reductionLoop(delta Size, excludeIndexes []int, callStackPosition int) {
+func (f *FlexModel) reductionLoop(delta Size, excludeIndexes []int, callStackPosition int) error {
 
 	if callStackPosition > 1 {
-		panic("reductionLoop shouldn't recurse more than once")
+		return fmt.Errorf("model [%d]: reductionLoop shouldn't recurse more than once", f.id)
 	}
 
 	if delta >= 0 {
-		panic("Delta must be negative")
+		return fmt.Errorf("model [%d]: reduction delta must be negative, got %d", f.id, delta)
+	}
+	if callStackPosition == 0 {
+		f.invalidated = nil
 	}
 
 	// Try to observe global min constraints again
@@ -174,11 +343,18 @@ func (f *FlexModel) reductionLoop(delta Size, excludeIndexes []int, callStackPos
 	rem := delta
 	proceed := true
 	for proceed {
-		rem = rebalance(f.sizes(compl), f.minimums(compl), rem)
+		var err error
+		rem, err = rebalance(f.sizes(compl), f.minimums(compl), rem)
+		if err != nil {
+			return fmt.Errorf("model [%d]: %w", f.id, err)
+		}
 		proceed = rem != 0 && chain.Invalidate()
 	}
 
 	f.constraints = chain.userDefined
+	for _, c := range chain.invalidated {
+		f.invalidated = append(f.invalidated, c.String())
+	}
 
 	// Reset global state: note this strangeness is ultimately due to FlexModel implementing GetMin itself
 	// As opposed to the chain for example, which may be more appropriate
@@ -189,21 +365,45 @@ func (f *FlexModel) reductionLoop(delta Size, excludeIndexes []int, callStackPos
 	// there is still some remaining (i.e. hard limits prevent further growth)
 	// adjust the flexed items back down
 	if rem < 0 {
-		log.Printf("Invalidating constraints wasn't enough. These are too large. Reducing back.")
-		f.reductionLoop(rem, compl, callStackPosition+1)
+		logDebug(logModel, "Invalidating constraints wasn't enough. These are too large. Reducing back.")
+		return f.reductionLoop(rem, compl, callStackPosition+1)
+	}
+	return nil
+}
+
+// Flexes the item at idx with the model's sizing strategy. Returns true if any sizes changed.
+func (f *FlexModel) Flex(idx int) (bool, error) {
+	f.touch(idx)
+	if pinned := f.pinnedIndex(); pinned >= 0 && pinned != idx {
+		return f.flexAroundPin(idx, pinned)
 	}
+	return f.strategy().Flex(f, idx)
+}
 
+// Returns the model's own sizing strategy, falling back to the global one
+func (f *FlexModel) strategy() Sizing {
+	if f.sizing != nil {
+		return f.sizing
+	}
+	if f.globals.strategy != nil {
+		return f.globals.strategy
+	}
+	return constraintSizing{}
 }
 
-func (f *FlexModel) Flex(idx int) bool {
+func (f *FlexModel) flexWithConstraints(idx int) (bool, error) {
 	toFlex := f.items[idx]
 	if isFlexed(toFlex.current) {
-		// Already flexed. Nothing to do
-		return false
+		// Already flexed. Nothing to do, unless it has outgrown maxFlex
+		surplus := f.capFlex(idx)
+		if surplus == 0 {
+			return false, nil
+		}
+		return true, f.grow(f.unflexed(), surplus)
 	}
 
 	for _, v := range f.items {
-		log.Printf("preflex item current %d", v.current)
+		logTrace(logModel, "preflex item current %d", v.current)
 	}
 	delta := Size(0)
 	excludeIndexes := make([]int, 0, 2)
@@ -211,15 +411,10 @@ func (f *FlexModel) Flex(idx int) bool {
 
 	// Add to the size of the element that will be flexed
 	// Subtract from the delta
-	var newFlexSize Size
-	if toFlex.softMinFlex > 0 {
-		newFlexSize = toFlex.softMinFlex
-	} else {
-		newFlexSize = f.globals.softMinFlex
-	}
+	newFlexSize := f.flexTarget(idx)
 	delta = delta - (newFlexSize - toFlex.current)
 	toFlex.current = newFlexSize
-	log.Printf("New flex size %d", toFlex.current)
+	logTrace(logModel, "New flex size %d", toFlex.current)
 
 	// Subtract from the size of the element that will be unflexed
 	// Add to the delta
@@ -230,36 +425,171 @@ func (f *FlexModel) Flex(idx int) bool {
 		// Shrink this item down
 		// Should just be one
 		if isFlexed(item.current) {
-			min := f.globals.softMinUnflex
-			if item.softMinUnflex > 0 {
-				min = item.softMinUnflex
-			}
+			min := f.unflexTarget(k)
 			delta = delta + (item.current - min)
 			item.current = min
 			excludeIndexes = append(excludeIndexes, k)
 		}
 	}
 	for _, v := range f.items {
-		log.Printf("With flex current %d", v.current)
+		logTrace(logModel, "With flex current %d", v.current)
 	}
 	if len(excludeIndexes) > 2 { // sanity
-		log.Print("Warning: More than one item was shrunken, indicating multiple flexed items.")
+		logWarn(logModel, "More than one item was shrunken in model [%d], indicating multiple flexed items.", f.id)
 	}
 
 	if delta > 0 {
 		// Shrunk more: distribute among unflexed
-		unflexed := f.unflexed()
-		rebalance(f.sizes(unflexed), f.minimums(unflexed), delta)
+		if err := f.grow(f.unflexed(), delta); err != nil {
+			return true, err
+		}
 	} else if delta < 0 {
 		// Grew more: proceed with reduction loop
-		log.Printf("delta! %d", delta)
-		f.reductionLoop(delta, excludeIndexes, 0)
+		logTrace(logModel, "delta! %d", delta)
+		if err := f.reductionLoop(delta, excludeIndexes, 0); err != nil {
+			return true, err
+		}
 	}
-	log.Printf("delta %d", delta)
+	logTrace(logModel, "delta %d", delta)
 	for _, v := range f.items {
-		log.Printf("postflex item current %d", v.current)
+		logTrace(logModel, "postflex item current %d", v.current)
+	}
+	return true, nil
+}
+
+// Moves the item to the front of the focus history
+func (f *FlexModel) touch(idx int) {
+	recent := make([]int, 0, len(f.recent)+1)
+	recent = append(recent, idx)
+	for _, i := range f.recent {
+		if i != idx {
+			recent = append(recent, i)
+		}
+	}
+	f.recent = recent
+}
+
+// Returns how recently each item was flexed: 0 for the most recent,
+// and len(f.recent) for the items which never were
+func (f *FlexModel) recencyRanks() []int {
+	ranks := make([]int, len(f.items))
+	for i := range ranks {
+		ranks[i] = len(f.recent)
+	}
+	for rank, i := range f.recent {
+		ranks[i] = rank
+	}
+	return ranks
+}
+
+// Replaces the sizes of all items, returning true if any of them changed
+func (f *FlexModel) setSizes(sizes []Size) bool {
+	changed := false
+	for i, item := range f.items {
+		if item.current != sizes[i] {
+			item.current = sizes[i]
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Raises the sizes below the hard minimum for unflexed items, taking the difference from the flexed item
+func (f *FlexModel) raiseToHardMin(sizes []Size, flexed int) {
+	for i := range sizes {
+		if i != flexed && sizes[i] < f.globals.hardMinUnflex {
+			sizes[flexed] = sizes[flexed] - (f.globals.hardMinUnflex - sizes[i])
+			sizes[i] = f.globals.hardMinUnflex
+		}
+	}
+}
+
+// Returns the size a flexed item shrinks to when it's unflexed
+func (f *FlexModel) unflexTarget(idx int) Size {
+	if item := f.items[idx]; item.softMinUnflex > 0 {
+		return item.softMinUnflex
+	}
+	return f.globals.softMinUnflex
+}
+
+// Keeps the item flexed regardless of focus, unpinning any other item in the model.
+// Returns true if the sizes changed.
+func (f *FlexModel) Pin(idx int) (bool, error) {
+	for _, item := range f.items {
+		item.pinned = false
+	}
+	f.touch(idx)
+	changed, err := f.flexPinned(idx)
+	f.items[idx].pinned = true
+	return changed, err
+}
+
+// Flexes the pinned item with the model's sizing strategy.
+// Strategies which don't flex it, like equal, give way to its flex target, so it's always the largest.
+func (f *FlexModel) flexPinned(idx int) (bool, error) {
+	changed, err := f.strategy().Flex(f, idx)
+	if err != nil || isFlexed(f.items[idx].current) {
+		return changed, err
+	}
+	flexed, err := f.flexWithConstraints(idx)
+	return changed || flexed, err
+}
+
+func (f *FlexModel) Unpin(idx int) {
+	f.items[idx].pinned = false
+}
+
+// Returns the index of the pinned item, or -1
+func (f *FlexModel) pinnedIndex() int {
+	for i, item := range f.items {
+		if item.pinned {
+			return i
+		}
 	}
-	return true
+	return -1
+}
+
+// Focusing an item besides the pinned one leaves the pinned item flexed.
+// Instead, the other unflexed items shrink to their minimum,
+// and the focused item takes up the rest of the space the pinned item leaves.
+func (f *FlexModel) flexAroundPin(idx int, pinned int) (bool, error) {
+	changed := false
+	if !isFlexed(f.items[pinned].current) {
+		// Resized away from being flexed, so flex it back first
+		var err error
+		if changed, err = f.flexPinned(pinned); err != nil {
+			return changed, err
+		}
+	}
+
+	freed := Size(0)
+	for k, item := range f.items {
+		if k == idx || k == pinned {
+			continue
+		}
+		if min := f.unflexTarget(k); item.current > min {
+			freed = freed + item.current - min
+			item.current = min
+		}
+	}
+	if freed == 0 {
+		return changed, nil
+	}
+	logDebug(logModel, "Flexing [%d] around pinned [%d], freed %d", f.items[idx].id, f.items[pinned].id, freed)
+	f.items[idx].current = f.items[idx].current + freed
+	return true, nil
+}
+
+// Checks the sizes of the items still add up to the whole container
+func (f *FlexModel) checkScale() error {
+	sizes := make([]*Size, 0, len(f.items))
+	for _, item := range f.items {
+		sizes = append(sizes, &item.current)
+	}
+	if err := checkScaleSizes(sizes); err != nil {
+		return fmt.Errorf("model [%d]: %w", f.id, err)
+	}
+	return nil
 }
 
 func (f *FlexModel) sizes(indexes []int) []*Size {
@@ -293,6 +623,19 @@ func (f *FlexModel) unflexed() []int {
 // If the item is flexed, adds a FlexItemMinFlexConstraint
 // If the item is unflexed, adds a FlexItemMinUnflexConstraint
 // If such a constraint exists, it is simply bumped to the top
+// Adds constraints for the minimums the items start out with, i.e. those set by rules,
+// so they give way like the user's own when the other items can't fit beside them.
+func (f *FlexModel) constrainItemMins() {
+	for idx, item := range f.items {
+		if item.softMinFlex > 0 {
+			f.constraints = append(f.constraints, FlexItemMinFlexConstraint{item, idx})
+		}
+		if item.softMinUnflex > 0 {
+			f.constraints = append(f.constraints, FlexItemMinUnflexConstraint{item, idx})
+		}
+	}
+}
+
 func (f *FlexModel) putConstraint(idx int) {
 	item := f.items[idx]
 	isFlexConstraint := isFlexed(item.current)
@@ -342,6 +685,21 @@ func (f *FlexModel) complement(indexes []int) []int {
 	return compl
 }
 
+// Returns the index of the item with the id, or of the tabbed or stacked item holding it.
+// Returns -1 if neither is in the model.
+func (f *FlexModel) itemIndex(id i3.NodeID) int {
+	memberIdx := -1
+	for i, item := range f.items {
+		if item.id == id {
+			return i
+		}
+		if memberIdx < 0 && item.members[id] {
+			memberIdx = i
+		}
+	}
+	return memberIdx
+}
+
 func (f *FlexModel) GetMin(idx int) Size {
 	item := f.items[idx]
 	if isFlexed(item.current) {
@@ -364,11 +722,19 @@ func (f *FlexModel) GetMin(idx int) Size {
 }
 
 type FlexItem struct {
-	id      i3.NodeID
-	current Size
+	id       i3.NodeID
+	window   int64
+	members  map[i3.NodeID]bool // For tabbed and stacked items, every container within them
+	current  Size
+	previous Size // As of the last sync with i3
+	expected Size // What i3 should have after the last render, 0 if unknown
 
 	// Stores user overrides for flex items
 	// <= 0 means unspecified
 	softMinFlex   Size
 	softMinUnflex Size
+	flexTarget    Size // What to flex to instead of the default flex ratio
+
+	pinned bool // Stays flexed when other items in the model are focused
+	shrunk bool // The user shrunk it to softMinUnflex, so it isn't grown while others can be
 }
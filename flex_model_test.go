@@ -0,0 +1,337 @@
+package main
+
+import (
+	"testing"
+
+	"go.i3wm.org/i3/v4"
+)
+
+func testModel(globals GlobalSizings, sizes ...Size) *FlexModel {
+	items := make([]*FlexItem, 0, len(sizes))
+	for i, size := range sizes {
+		items = append(items, &FlexItem{id: i3.NodeID(i + 1), current: size})
+	}
+	return &FlexModel{
+		id:          100,
+		direction:   Horizontal,
+		globals:     globals,
+		items:       items,
+		constraints: make([]MinItemConstraint, 0),
+	}
+}
+
+func checkTotal(t *testing.T, f *FlexModel) {
+	total := Size(0)
+	for _, item := range f.items {
+		total = total + item.current
+	}
+	if total != normal {
+		t.Errorf("expected sizes to add up to %d, got %d", normal, total)
+	}
+}
+
+func TestFlexUsesDefaultFlexRatio(t *testing.T) {
+	globals := defaultGlobalSizings()
+	globals.defaultFlexRatio = Ratio{7, 10}
+	f := testModel(globals, 333, 333, 334)
+	if changed, err := f.Flex(0); err != nil || !changed {
+		t.Fatalf("expected the item to be flexed, got %v", err)
+	}
+	if f.items[0].current != 700 {
+		t.Errorf("expected the flexed item to be 700, got %d", f.items[0].current)
+	}
+	checkTotal(t, f)
+}
+
+func TestFlexCapsFlexTarget(t *testing.T) {
+	globals := defaultGlobalSizings()
+	f := testModel(globals, 500, 500)
+	f.items[1].flexTarget = 950
+	f.Flex(1)
+	if f.items[1].current != globals.maxFlex {
+		t.Errorf("expected the flex target to be capped at %d, got %d", globals.maxFlex, f.items[1].current)
+	}
+	checkTotal(t, f)
+}
+
+func TestOnUpdateCapsAtMaxFlex(t *testing.T) {
+	globals := defaultGlobalSizings()
+	f := testModel(globals, 800, 100, 100)
+	f.OnUpdate([]FlexEvent{{id: 1, increase: 150}})
+	if f.items[0].current != globals.maxFlex {
+		t.Errorf("expected growth to be capped at %d, got %d", globals.maxFlex, f.items[0].current)
+	}
+	checkTotal(t, f)
+}
+
+func TestShrinkPolicies(t *testing.T) {
+	expected := map[shrinkPolicy][]Size{
+		proportionalShrinkPolicy: {675, 225, 100},
+		flexedShrinkPolicy:       {700, 200, 100},
+		unflexedShrinkPolicy:     {600, 300, 100},
+	}
+	for policy, sizes := range expected {
+		globals := defaultGlobalSizings()
+		globals.shrinkPolicy = policy
+		f := testModel(globals, 600, 200, 200)
+		f.OnUpdate([]FlexEvent{{id: 3, increase: -100}})
+		for i, size := range sizes {
+			if f.items[i].current != size {
+				t.Errorf("%s: expected item %d to be %d, got %d", policy, i, size, f.items[i].current)
+			}
+		}
+		checkTotal(t, f)
+	}
+}
+
+func TestShrinkIsKept(t *testing.T) {
+	update := func(a, b, c int) []FlexUpdate {
+		return []FlexUpdate{{ExternalId: 1, Direction: Horizontal, Items: []FlexItemUpdate{
+			{ExternalId: 2, Size: a}, {ExternalId: 3, Size: b}, {ExternalId: 4, Size: c},
+		}}}
+	}
+	fm := initFlexModels(defaultGlobalSizings())
+	fm.Updates(update(333, 333, 334), true)
+	fm.OnFocus(2)
+	model := fm.models[1]
+
+	// The user drags the divider between the unflexed items
+	fm.Updates(update(620, 260, 120), false)
+	if min := model.GetMin(2); min != 120 {
+		t.Errorf("expected the shrunk item's min to be lowered to 120, got %d", min)
+	}
+	fm.OnFocus(3)
+	if !isFlexed(model.items[1].current) || model.items[2].current != 120 {
+		t.Errorf("expected the shrunk item to stay at 120: %d, %d, %d",
+			model.items[0].current, model.items[1].current, model.items[2].current)
+	}
+	checkTotal(t, model)
+
+	// The user shrinks the flexed item, which stays flexed
+	fm.Updates(update(200, 560, 240), false)
+	fm.OnFocus(2)
+	fm.OnFocus(3)
+	if model.items[1].current != 560 {
+		t.Errorf("expected the flexed item to be flexed to where it was shrunk, got %d", model.items[1].current)
+	}
+	checkTotal(t, model)
+}
+
+func TestFlexAroundPin(t *testing.T) {
+	globals := defaultGlobalSizings()
+	globals.defaultFlexRatio = Ratio{7, 10}
+	f := testModel(globals, 333, 333, 334)
+	f.Pin(0)
+	pinned := f.items[0].current
+	if !isFlexed(pinned) {
+		t.Fatalf("expected the pinned item to be flexed, got %d", pinned)
+	}
+
+	f.Flex(1)
+	if f.items[0].current != pinned {
+		t.Errorf("expected the pinned item to stay at %d, got %d", pinned, f.items[0].current)
+	}
+	if f.items[2].current != globals.softMinUnflex {
+		t.Errorf("expected the other item to shrink to %d, got %d", globals.softMinUnflex, f.items[2].current)
+	}
+	checkTotal(t, f)
+
+	f.Unpin(0)
+	f.Flex(1)
+	if !isFlexed(f.items[1].current) || isFlexed(f.items[0].current) {
+		t.Errorf("expected focus to flex the item once unpinned: %d, %d", f.items[0].current, f.items[1].current)
+	}
+	checkTotal(t, f)
+}
+
+func TestPinFlexesWhateverTheSizing(t *testing.T) {
+	f := testModel(defaultGlobalSizings(), 333, 333, 334)
+	f.sizing = equalSizing{}
+	f.Pin(0)
+	f.Flex(1)
+	if !isFlexed(f.items[0].current) || f.items[1].current >= f.items[0].current {
+		t.Errorf("expected the pinned item to stay flexed: %d, %d, %d", f.items[0].current, f.items[1].current, f.items[2].current)
+	}
+	checkTotal(t, f)
+}
+
+func TestRebuildKeepsPinAndSizing(t *testing.T) {
+	update := func(ids ...i3.NodeID) []FlexUpdate {
+		items := make([]FlexItemUpdate, 0, len(ids))
+		for _, id := range ids {
+			items = append(items, FlexItemUpdate{ExternalId: id, Size: 100})
+		}
+		return []FlexUpdate{{ExternalId: 1, Direction: Horizontal, Items: items}}
+	}
+	fm := initFlexModels(defaultGlobalSizings())
+	fm.Updates(update(2, 3), true)
+	fm.Pin(2)
+	fm.SetSizing(1, goldenSizing{})
+
+	// A terminal opens next to the pinned window
+	fm.Updates(update(2, 3, 4), true)
+	model := fm.models[1]
+	if len(model.items) != 3 || model.pinnedIndex() != 0 {
+		t.Errorf("expected the pin to be kept when the model was rebuilt, pinned index %d", model.pinnedIndex())
+	}
+	if model.strategy().Name() != "golden" {
+		t.Errorf("expected the sizing to be kept when the model was rebuilt, got %s", model.strategy().Name())
+	}
+}
+
+func TestSizingStrategies(t *testing.T) {
+	globals := defaultGlobalSizings()
+	for _, test := range []struct {
+		sizing   Sizing
+		expected []Size
+	}{
+		{equalSizing{}, []Size{334, 333, 333}},
+		{goldenSizing{}, []Size{235, 619, 146}},
+		{fixedSizing{}, []Size{191, 619, 190}},
+	} {
+		f := testModel(globals, 333, 333, 334)
+		f.sizing = test.sizing
+		f.Flex(1)
+		for i, item := range f.items {
+			if item.current != test.expected[i] {
+				t.Errorf("%s: expected sizes %v, got item %d = %d", test.sizing.Name(), test.expected, i, item.current)
+				break
+			}
+		}
+		checkTotal(t, f)
+	}
+}
+
+func TestRecencySizing(t *testing.T) {
+	globals := defaultGlobalSizings()
+	f := testModel(globals, 250, 250, 250, 250)
+	f.sizing = recencySizing{}
+	f.Flex(1)
+	f.Flex(2)
+	f.Flex(3)
+	sizes := f.sizes([]int{0, 1, 2, 3})
+	if !(*sizes[2] > *sizes[1] && *sizes[1] > *sizes[0]) {
+		t.Errorf("expected the most recently flexed items to be larger: %d, %d, %d", *sizes[0], *sizes[1], *sizes[2])
+	}
+	if *sizes[0] < globals.softMinUnflex {
+		t.Errorf("expected the soft minimum %d to be kept, got %d", globals.softMinUnflex, *sizes[0])
+	}
+	if !isFlexed(*sizes[3]) {
+		t.Errorf("expected the focused item to be flexed, got %d", *sizes[3])
+	}
+	checkTotal(t, f)
+}
+
+func TestOnFocusFlexesAncestors(t *testing.T) {
+	// splith 1 > splith 3 > splitv 5 > window 7
+	updates := []FlexUpdate{
+		{ExternalId: 1, Direction: Horizontal, Items: []FlexItemUpdate{{ExternalId: 2, Size: 500}, {ExternalId: 3, Size: 500}}},
+		{ExternalId: 3, Direction: Horizontal, Items: []FlexItemUpdate{{ExternalId: 4, Size: 500}, {ExternalId: 5, Size: 500}}},
+		{ExternalId: 5, Direction: Vertical, Items: []FlexItemUpdate{{ExternalId: 6, Size: 500}, {ExternalId: 7, Size: 500}}},
+	}
+	for _, test := range []struct {
+		propagation focusPropagation
+		flexed      []i3.NodeID
+	}{
+		{focusPropagation{sameDirection: true}, []i3.NodeID{3, 5, 7}},
+		{focusPropagation{maxDepth: 1, sameDirection: true}, []i3.NodeID{5, 7}},
+		{focusPropagation{sameDirection: false}, []i3.NodeID{5, 7}},
+	} {
+		fm := initFlexModels(defaultGlobalSizings())
+		fm.SetPropagation(test.propagation)
+		fm.Updates(updates, true)
+		fm.OnFocus(7)
+		flexed := make([]i3.NodeID, 0)
+		for _, id := range []i3.NodeID{1, 3, 5} {
+			for _, item := range fm.models[id].items {
+				if isFlexed(item.current) {
+					flexed = append(flexed, item.id)
+				}
+			}
+		}
+		if len(flexed) != len(test.flexed) {
+			t.Errorf("%+v: expected %v to be flexed, got %v", test.propagation, test.flexed, flexed)
+			continue
+		}
+		for i := range flexed {
+			if flexed[i] != test.flexed[i] {
+				t.Errorf("%+v: expected %v to be flexed, got %v", test.propagation, test.flexed, flexed)
+				break
+			}
+		}
+	}
+}
+
+func TestOwnResizesAreIgnored(t *testing.T) {
+	update := func(a, b int) []FlexUpdate {
+		return []FlexUpdate{{ExternalId: 1, Direction: Horizontal, Items: []FlexItemUpdate{
+			{ExternalId: 2, Size: a}, {ExternalId: 3, Size: b},
+		}}}
+	}
+	fm := initFlexModels(defaultGlobalSizings())
+	fm.Updates(update(500, 500), true)
+	fm.OnFocus(2)
+	model := fm.models[1]
+	flexed := model.items[0].current
+
+	// i3 rounds the render to percents
+	fm.Updates(update(620, 380), false)
+	if model.items[0].current != flexed || len(model.constraints) != 0 {
+		t.Errorf("the rendered sizes were taken as a resize: %d, %+v", model.items[0].current, model.constraints)
+	}
+
+	fm.Updates(update(700, 300), false)
+	if model.items[0].current != 700 {
+		t.Errorf("the resize by the user wasn't taken: %d", model.items[0].current)
+	}
+}
+
+func TestOnUpdateGrowingPastTheFlexedItem(t *testing.T) {
+	f := testModel(defaultGlobalSizings(), 600, 200, 200)
+	if err := f.OnUpdate([]FlexEvent{{id: 3, increase: 500}}); err != nil {
+		t.Fatal(err)
+	}
+	for i, item := range f.items {
+		if item.current < f.globals.hardMinUnflex {
+			t.Errorf("expected item %d to keep its hard minimum, got %d", i, item.current)
+		}
+	}
+	if !isFlexed(f.items[2].current) || isFlexed(f.items[0].current) {
+		t.Errorf("expected the grown item to take over being flexed: %d, %d", f.items[0].current, f.items[2].current)
+	}
+	checkTotal(t, f)
+}
+
+func TestBrokenModelsAreDropped(t *testing.T) {
+	fm := initFlexModels(defaultGlobalSizings())
+	fm.Updates([]FlexUpdate{{ExternalId: 1, Direction: Horizontal, Items: []FlexItemUpdate{
+		{ExternalId: 2, Size: 500}, {ExternalId: 3, Size: 500},
+	}}}, true)
+	model := fm.models[1]
+	model.items[0].current = 900
+	fm.render([]*FlexModel{model})
+	if _, ok := fm.models[1]; ok {
+		t.Error("expected the model whose sizes don't add up to be dropped")
+	}
+}
+
+func TestRuleMinimumsGiveWay(t *testing.T) {
+	for _, test := range []struct {
+		sizes []Size
+		min   Size
+	}{
+		{[]Size{500, 500}, 450},
+		{[]Size{334, 333, 333}, 400},
+	} {
+		f := testModel(defaultGlobalSizings(), test.sizes...)
+		f.items[0].softMinUnflex = test.min
+		f.constrainItemMins()
+		if _, err := f.Flex(1); err != nil {
+			t.Fatalf("flexing beside a rule minimum of %d: %s", test.min, err)
+		}
+		if !isFlexed(f.items[1].current) || f.items[0].current < f.globals.hardMinUnflex {
+			t.Errorf("unexpected sizes with a rule minimum of %d: %d, %d", test.min, f.items[0].current, f.items[1].current)
+		}
+		checkTotal(t, f)
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"fmt"
 
 	"go.i3wm.org/i3/v4"
 )
@@ -10,11 +10,27 @@ import (
 type FlexModels struct {
 	models   map[i3.NodeID]*FlexModel
 	renderer FlexRenderer
+	sizings  GlobalSizings // Used for newly created models
+	rules    []windowRule  // Applied to newly created items
+	areas    *areaFilter   // Containers outside of these aren't modelled
+
+	propagation focusPropagation
+
+	interrupted map[i3.NodeID]bool // Models whose rendering was stopped partway by Settle, to be finished
+
+	// Whether to keep the models' sizes when they differ from i3's.
+	// In a dry run nothing is rendered, so the differences aren't resizes by the user.
+	ignoreResizes bool
 }
 
 func (f *FlexModels) RegisterRenderer(renderer FlexRenderer) { f.renderer = renderer }
+func (f *FlexModels) SetRules(rules []windowRule)            { f.rules = rules }
+func (f *FlexModels) SetAreas(areas *areaFilter)             { f.areas = areas }
+func (f *FlexModels) IgnoreResizes(ignore bool)              { f.ignoreResizes = ignore }
+func (f *FlexModels) SetPropagation(p focusPropagation)      { f.propagation = p }
 
 func (f *FlexModels) Updates(updates []FlexUpdate, full bool) {
+	updates = f.allowedUpdates(updates)
 	markForPrune := make(map[i3.NodeID]bool)
 	// If full, prune all by default. Otherwise none
 	for k, _ := range f.models {
@@ -24,31 +40,79 @@ func (f *FlexModels) Updates(updates []FlexUpdate, full bool) {
 	for _, update := range updates {
 		model, ok := f.models[update.ExternalId]
 		if ok {
-			markForPrune[update.ExternalId] = f.isInvalidated(update, model)
+			invalidated, err := f.isInvalidated(update, model)
+			if err != nil {
+				logError(logModel, "Error checking model [%d] against its update: %s", model.id, err.Error())
+				invalidated = true
+			}
+			markForPrune[update.ExternalId] = invalidated
 		}
 	}
-	// Prune everything that's been marked
+	// Prune everything that's been marked, keeping them around for the models that replace them
+	replaced := make(map[i3.NodeID]*FlexModel)
 	for k, v := range markForPrune {
 		if v {
+			replaced[k] = f.models[k]
 			delete(f.models, k)
 		}
 	}
 
+	// Resizes by the user are rebalanced in the models, and have to be rendered to match
+	resized := make([]*FlexModel, 0)
 	for _, update := range updates {
-		f.update(update)
+		model, err := f.update(update, replaced[update.ExternalId])
+		if err != nil {
+			logError(logModel, "Error updating model [%d]: %s", update.ExternalId, err.Error())
+			f.drop(update.ExternalId)
+		} else if model != nil {
+			resized = append(resized, model)
+		}
+	}
+	// Rendering stopped to read the layout back is finished from where it was
+	for id := range f.interrupted {
+		model, ok := f.models[id]
+		if ok && replaced[id] == nil && !containsModel(resized, model) {
+			resized = append(resized, model)
+		}
 	}
+	f.interrupted = nil
+	if len(resized) > 0 {
+		f.render(resized)
+	}
+}
+
+func containsModel(models []*FlexModel, model *FlexModel) bool {
+	for _, m := range models {
+		if m == model {
+			return true
+		}
+	}
+	return false
 }
 
-func (f *FlexModels) isInvalidated(update FlexUpdate, model *FlexModel) bool {
+// Filters out the updates for containers in disabled areas, dropping their models
+func (f *FlexModels) allowedUpdates(updates []FlexUpdate) []FlexUpdate {
+	allowed := make([]FlexUpdate, 0, len(updates))
+	for _, update := range updates {
+		if f.areas.allows(update.Workspace, update.Output) {
+			allowed = append(allowed, update)
+		} else {
+			delete(f.models, update.ExternalId)
+		}
+	}
+	return allowed
+}
+
+func (f *FlexModels) isInvalidated(update FlexUpdate, model *FlexModel) (bool, error) {
 	if update.ExternalId != model.id {
-		panic("ExternalId must be the same as id")
+		return false, fmt.Errorf("update for [%d] doesn't match model [%d]", update.ExternalId, model.id)
 	}
 	if update.Direction != model.direction {
 		// Invalidate it if it changed directions somehow
-		return true
+		return true, nil
 	}
 	if len(update.Items) != len(model.items) {
-		return true
+		return true, nil
 	}
 	dupeCheck := make(map[i3.NodeID]bool)
 	for _, item := range model.items {
@@ -58,21 +122,28 @@ func (f *FlexModels) isInvalidated(update FlexUpdate, model *FlexModel) bool {
 		_, ok := dupeCheck[item.ExternalId]
 		if !ok {
 			// Unique item
-			return true
+			return true, nil
 		}
 	}
 
-	return false
+	return false, nil
 }
 
-func (f *FlexModels) update(update FlexUpdate) {
+// Creates or updates the model for the update. A created model inherits from the one it replaces, if any.
+// Returns the model if it was changed in response to resizes by the user.
+func (f *FlexModels) update(update FlexUpdate, replaced *FlexModel) (*FlexModel, error) {
 	scaled := make([]*int, 0, len(update.Items))
 	for _, item := range update.Items {
 		sizeCopy := item.Size
 		scaled = append(scaled, &sizeCopy)
 	}
-	rescale(scaled, 0, normal) // infer the scale from the total length
-	checkScale(scaled, normal)
+	// infer the scale from the total length
+	if err := rescale(scaled, 0, normal); err != nil {
+		return nil, err
+	}
+	if err := checkScale(scaled, normal); err != nil {
+		return nil, err
+	}
 
 	model, ok := f.models[update.ExternalId]
 	if ok {
@@ -82,9 +153,16 @@ func (f *FlexModels) update(update FlexUpdate) {
 			for _, item := range model.items {
 				// TODO: O(n^2), I know who cares, but it could be bad
 				if itemUpdate.ExternalId == item.id {
-					increase := Size(*scaled[i]) - item.current
-					if increase > 0 {
-						log.Printf("increase %d", increase)
+					item.members = memberSet(itemUpdate.Members)
+					item.previous = Size(*scaled[i])
+					if f.ignoreResizes || !f.isUserResize(item, item.previous) {
+						break
+					}
+					// The layout isn't what was rendered anymore
+					item.expected = 0
+					increase := item.previous - item.current
+					if increase != 0 {
+						logDebug(logModel, "Resize by the user of [%d] in model [%d] by %d", item.id, model.id, increase)
 						events = append(events, FlexEvent{
 							item.id,
 							increase,
@@ -95,81 +173,318 @@ func (f *FlexModels) update(update FlexUpdate) {
 			}
 		}
 		if len(events) > 0 {
-			model.OnUpdate(events)
+			if err := model.OnUpdate(events); err != nil {
+				return nil, err
+			}
+			return model, nil
 		}
 	} else {
 		items := make([]*FlexItem, 0, len(update.Items))
 		for i, itemUpdate := range update.Items {
-			items = append(items, &FlexItem{
-				id:      itemUpdate.ExternalId,
-				current: Size(*scaled[i]),
-			})
+			item := &FlexItem{
+				id:       itemUpdate.ExternalId,
+				window:   itemUpdate.Window,
+				members:  memberSet(itemUpdate.Members),
+				current:  Size(*scaled[i]),
+				previous: Size(*scaled[i]),
+			}
+			if item.window != 0 {
+				applyRules(f.rules, item, itemUpdate.Properties)
+			}
+			items = append(items, item)
 		}
 		model := &FlexModel{
 			id:          update.ExternalId,
 			direction:   update.Direction,
-			globals:     globals,
+			globals:     f.sizings,
 			items:       items,
 			constraints: make([]MinItemConstraint, 0),
 		}
+		model.constrainItemMins()
+		if replaced != nil {
+			model.inherit(replaced)
+		}
 		f.models[update.ExternalId] = model
 	}
+	return nil, nil
+}
+
+// Keeps what was set by hand on the model it replaces, when its items came or went:
+// its sizing strategy, and pinned items stay pinned as long as they're still there.
+func (f *FlexModel) inherit(replaced *FlexModel) {
+	f.sizing = replaced.sizing
+	for _, old := range replaced.items {
+		if !old.pinned {
+			continue
+		}
+		for _, item := range f.items {
+			if item.id == old.id {
+				item.pinned = true
+			}
+		}
+	}
 }
 
-func (f *FlexModels) OnFocus(id i3.NodeID) {
-	found := false
+// How focus propagates from the focused item's model to the models of its ancestors
+type focusPropagation struct {
+	maxDepth      int  // How many ancestor models are flexed, 0 for all of them
+	sameDirection bool // Whether ancestors split in the same direction as their child are flexed
+}
+
+// Whether the item being resized to the measured size was the user's doing.
+// Being within the tolerance of what was last rendered isn't, nor is changing only by the tolerance,
+// since i3 rounds sizes to percents and pixels.
+func (f *FlexModels) isUserResize(item *FlexItem, measured Size) bool {
+	reference := item.current
+	if item.expected > 0 {
+		reference = item.expected
+	}
+	diff := measured - reference
+	return diff > f.sizings.resizeTolerance || -diff > f.sizings.resizeTolerance
+}
+
+// Flexes the item with the given id, and the items on its path in the models of its ancestors.
+// Returns false if no model contains the id.
+func (f *FlexModels) OnFocus(id i3.NodeID) bool {
 	toRender := make([]*FlexModel, 0)
-	var firstModel *FlexModel = nil
 	// Find the id as an item in one of the models
-	for _, model := range f.models {
-		for i, item := range model.items {
-			if item.id == id {
-				found = true
-				log.Printf("Flexing [%s] model [%d]-->[%d]", model.direction, model.id, id)
-				rerender := model.Flex(i)
-				if rerender {
-					toRender = append(toRender, model)
-				}
-				firstModel = model
-				break
+	firstModel, i := f.findItem(id)
+	if firstModel == nil {
+		return false
+	}
+	logInfo(logModel, "Flexing [%s] model [%d]-->[%d]", firstModel.direction, firstModel.id, id)
+	if f.flex(firstModel, i) {
+		toRender = append(toRender, firstModel)
+	}
+
+	// Walk up through the models with the previous model's container as an item.
+	// There can't be more ancestors than models, which also guards against cycles.
+	child := firstModel
+	for depth := 1; depth <= len(f.models); depth++ {
+		if f.propagation.maxDepth > 0 && depth > f.propagation.maxDepth {
+			break
+		}
+		model, i := f.findItem(child.id)
+		if model == nil {
+			break
+		}
+		if f.propagation.sameDirection || model.direction != child.direction {
+			logInfo(logModel, "Flexing [%s] ancestor model [%d]-->[%d]", model.direction, model.id, id)
+			if f.flex(model, i) {
+				toRender = append(toRender, model)
 			}
 		}
-		if found {
+		child = model
+	}
+
+	if len(toRender) > 0 {
+		f.render(toRender)
+	}
+	return true
+}
+
+// Pins the item with the given id in its model, so it stays flexed regardless of focus.
+// Returns false if no model contains the id.
+func (f *FlexModels) Pin(id i3.NodeID) bool {
+	model, i := f.findItem(id)
+	if model == nil {
+		return false
+	}
+	logInfo(logModel, "Pinning [%d] in model [%d]", model.items[i].id, model.id)
+	changed, err := model.Pin(i)
+	if err != nil {
+		logError(logModel, "Error pinning [%d] in model [%d]: %s", model.items[i].id, model.id, err.Error())
+		f.drop(model.id)
+	} else if changed {
+		f.render([]*FlexModel{model})
+	}
+	return true
+}
+
+// Lets the item with the given id be unflexed by focus again.
+// Returns false if no model contains the id.
+func (f *FlexModels) Unpin(id i3.NodeID) bool {
+	model, i := f.findItem(id)
+	if model == nil {
+		return false
+	}
+	logInfo(logModel, "Unpinning [%d] in model [%d]", model.items[i].id, model.id)
+	model.Unpin(i)
+	return true
+}
+
+// Sets the sizing strategy of the model for the container with the id, or of the model
+// with the id as an item. A nil strategy reverts the model to the global one.
+// Returns false if no model matches the id.
+func (f *FlexModels) SetSizing(id i3.NodeID, sizing Sizing) bool {
+	model, ok := f.models[id]
+	if !ok {
+		if model, _ = f.findItem(id); model == nil {
+			return false
+		}
+	}
+	model.sizing = sizing
+	logInfo(logModel, "Sizing model [%d] with [%s]", model.id, model.strategy().Name())
+	// Apply it right away to whichever item is flexed
+	for i, item := range model.items {
+		if isFlexed(item.current) {
+			if f.flex(model, i) {
+				f.render([]*FlexModel{model})
+			}
 			break
 		}
 	}
-	if !found {
+	return true
+}
+
+// Flexes the item at i in the model, returning true if the model needs rendering.
+// A model which fails to flex is dropped, to be rebuilt from the tree on the next sync.
+func (f *FlexModels) flex(model *FlexModel, i int) bool {
+	changed, err := model.Flex(i)
+	if err != nil {
+		logError(logModel, "Error flexing [%d] in model [%d]: %s", model.items[i].id, model.id, err.Error())
+		f.drop(model.id)
+		return false
+	}
+	return changed
+}
+
+// Finds the model with the id as an item. Failing that, finds the model with a
+// tabbed or stacked item holding the id, so focusing a tab flexes its stack.
+func (f *FlexModels) findItem(id i3.NodeID) (*FlexModel, int) {
+	var memberModel *FlexModel = nil
+	memberIdx := -1
+	for _, model := range f.models {
+		i := model.itemIndex(id)
+		if i < 0 {
+			continue
+		}
+		if model.items[i].id == id {
+			return model, i
+		}
+		memberModel, memberIdx = model, i
+	}
+	return memberModel, memberIdx
+}
+
+// Returns the models which have the id as one of their items, or hold it in a tabbed or stacked item
+func (f *FlexModels) modelsContaining(id i3.NodeID) []*FlexModel {
+	models := make([]*FlexModel, 0)
+	for _, model := range f.models {
+		if model.itemIndex(id) >= 0 {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// Renders the models, and records the sizes i3 should end up with,
+// so they can be told apart from resizes by the user when synced.
+// Models whose sizes don't add up are dropped instead of rendered.
+func (f *FlexModels) render(models []*FlexModel) {
+	valid := make([]*FlexModel, 0, len(models))
+	for _, model := range models {
+		if err := model.checkScale(); err != nil {
+			logError(logRenderer, "Error rendering: %s", err.Error())
+			f.drop(model.id)
+		} else {
+			valid = append(valid, model)
+		}
+	}
+	models = valid
+	if len(models) == 0 {
+		return
+	}
+
+	err := f.renderer.Render(models)
+	failed, ok := failedIds(err)
+	if !ok {
 		return
 	}
 
-	// Search for another model with this firstModel as a child
-	// and the direction being different
-	found = false
+	for _, model := range models {
+		// i3 keeps percents, so that's what the sizes get rounded to
+		ppts := itemPpts(model)
+		logInfo(logRenderer, "Rendered model [%d] %s at %v ppt", model.id, model.direction, ppts)
+		for i, ppt := range ppts {
+			item := model.items[i]
+			if failed[item.id] {
+				item.expected = 0
+			} else {
+				item.expected = Size(ppt * normal / 100)
+			}
+		}
+	}
+}
+
+// Logs the error from rendering, and returns the ids of the items whose resizes failed.
+// Returns false if the error doesn't tell which.
+func failedIds(err error) (map[i3.NodeID]bool, bool) {
+	failed := make(map[i3.NodeID]bool)
+	if err == nil {
+		return failed, true
+	}
+	logError(logRenderer, "Error rendering: %s", err.Error())
+	renderErr, ok := err.(*RenderError)
+	if !ok {
+		return failed, false
+	}
+	for _, failure := range renderErr.failures {
+		failed[failure.id] = true
+	}
+	return failed, true
+}
+
+// Stops any rendering still in progress. Must be done before reading the layout back from i3.
+// Items left partway are expected at the sizes they were left at, and their models are rendered
+// again on the next update. Items whose last resizes failed aren't expected anywhere anymore.
+func (f *FlexModels) Settle() {
+	renderer, ok := f.renderer.(settlingRenderer)
+	if !ok {
+		return
+	}
+	sizes, err := renderer.Settle()
+	failed, _ := failedIds(err)
 	for _, model := range f.models {
-		for i, item := range model.items {
-			if item.id == firstModel.id && model.direction != firstModel.direction {
-				found = true
-				log.Printf("Flexing [%s] parent model [%d]-->[%d]", model.direction, model.id, id)
-				rerender := model.Flex(i)
-				if rerender {
-					toRender = append(toRender, model)
+		for _, item := range model.items {
+			if size, ok := sizes[item.id]; ok {
+				item.expected = size
+				if f.interrupted == nil {
+					f.interrupted = make(map[i3.NodeID]bool)
 				}
-				break
+				f.interrupted[model.id] = true
+			}
+			if failed[item.id] {
+				item.expected = 0
 			}
-		}
-		if found {
-			break
 		}
 	}
+}
 
-	if len(toRender) > 0 {
-		f.renderer.Render(toRender)
-	}
+// Drops a single model, e.g. when its container no longer exists
+func (f *FlexModels) Remove(id i3.NodeID) {
+	delete(f.models, id)
+}
+
+// Drops a model which failed, so it's rebuilt from the tree on the next sync
+// rather than rendered in a bad state.
+func (f *FlexModels) drop(id i3.NodeID) {
+	logWarn(logModel, "Dropping model [%d], it will be rebuilt on the next sync", id)
+	delete(f.models, id)
 }
 
-func initFlexModels() *FlexModels {
+// Drops all models, along with everything learned about them
+func (f *FlexModels) Reset() {
+	f.models = make(map[i3.NodeID]*FlexModel)
+}
+
+func initFlexModels(sizings GlobalSizings) *FlexModels {
 	return &FlexModels{
 		models:   make(map[i3.NodeID]*FlexModel),
 		renderer: &fakeRenderer{},
+		sizings:  sizings,
+		areas:    newAreaFilter(),
+
+		propagation: focusPropagation{sameDirection: true},
 	}
 }
@@ -1,13 +1,49 @@
 package main
 
-import "log"
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"go.i3wm.org/i3/v4"
+)
 
 type FlexRenderer interface {
-	Render(models []*FlexModel)
+	Render(models []*FlexModel) error
+}
+
+// Implemented by renderers which go on rendering after Render returns
+type settlingRenderer interface {
+	// Stops rendering where it is, so the layout stays put while it's read back.
+	// Returns the sizes the items were left at, for those which didn't reach the models' sizes,
+	// and any error rendering the last sizes, like Render.
+	Settle() (map[i3.NodeID]Size, error)
 }
 
 type fakeRenderer struct{}
 
-func (f *fakeRenderer) Render(models []*FlexModel) {
-	log.Printf("Fake rendering %d models.\n %+v", len(models), models)
+func (f *fakeRenderer) Render(models []*FlexModel) error {
+	logTrace(logRenderer, "Fake rendering %d models.\n %+v", len(models), models)
+	return nil
+}
+
+// Prints the i3 commands which would be sent, and how each item would be resized,
+// without touching the layout.
+type dryRunRenderer struct {
+	out io.Writer
+}
+
+func (r *dryRunRenderer) Render(models []*FlexModel) error {
+	var b strings.Builder
+	for _, model := range models {
+		fmt.Fprintf(&b, "model [%d] %s\n", model.id, model.direction)
+		for _, cmd := range resizeCommands(model) {
+			fmt.Fprintf(&b, "  %s\n", cmd.cmd)
+		}
+		for _, item := range model.items {
+			fmt.Fprintf(&b, "  [%d] %d -> %d\n", item.id, item.previous, item.current)
+		}
+	}
+	_, err := io.WriteString(r.out, b.String())
+	return err
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// A serializable snapshot of a FlexModel
+type FlexModelState struct {
+	ID          i3.NodeID         `json:"id"`
+	Direction   FlexDirection     `json:"direction"`
+	Items       []FlexItemState   `json:"items"`
+	Constraints []ConstraintState `json:"constraints,omitempty"` // User defined constraints, in chain order
+	Sizing      string            `json:"sizing,omitempty"`      // The model's own sizing strategy, if it has one
+	Recent      []int             `json:"recent,omitempty"`      // Indexes into Items, most recently flexed first
+}
+
+type FlexItemState struct {
+	ID            i3.NodeID `json:"id"`
+	Window        int64     `json:"window,omitempty"`
+	Current       Size      `json:"current"`
+	Flexed        bool      `json:"flexed"`
+	SoftMinFlex   Size      `json:"soft_min_flex,omitempty"`
+	SoftMinUnflex Size      `json:"soft_min_unflex,omitempty"`
+	FlexTarget    Size      `json:"flex_target,omitempty"`
+	Pinned        bool      `json:"pinned,omitempty"`
+	Shrunk        bool      `json:"shrunk,omitempty"`
+}
+
+const (
+	flexConstraintKind   = "flex"
+	unflexConstraintKind = "unflex"
+)
+
+type ConstraintState struct {
+	Item int    `json:"item"` // Index into Items
+	Kind string `json:"kind"` // flexConstraintKind or unflexConstraintKind
+}
+
+func (f *FlexModel) State() FlexModelState {
+	items := make([]FlexItemState, 0, len(f.items))
+	for _, item := range f.items {
+		items = append(items, FlexItemState{
+			ID:            item.id,
+			Window:        item.window,
+			Current:       item.current,
+			Flexed:        isFlexed(item.current),
+			SoftMinFlex:   overrideState(item.softMinFlex),
+			SoftMinUnflex: overrideState(item.softMinUnflex),
+			FlexTarget:    overrideState(item.flexTarget),
+			Pinned:        item.pinned,
+			Shrunk:        item.shrunk,
+		})
+	}
+	constraints := make([]ConstraintState, 0, len(f.constraints))
+	for _, c := range f.constraints {
+		kind := unflexConstraintKind
+		if _, ok := c.(FlexItemMinFlexConstraint); ok {
+			kind = flexConstraintKind
+		}
+		constraints = append(constraints, ConstraintState{c.ItemIndex(), kind})
+	}
+	state := FlexModelState{
+		ID:          f.id,
+		Direction:   f.direction,
+		Items:       items,
+		Constraints: constraints,
+		Recent:      append([]int(nil), f.recent...),
+	}
+	if f.sizing != nil {
+		state.Sizing = f.sizing.Name()
+	}
+	return state
+}
+
+// Overrides <= 0 are unspecified, and all serialize as 0
+func overrideState(s Size) Size {
+	if s <= 0 {
+		return 0
+	}
+	return s
+}
+
+// Replaces the learned sizes, overrides and constraints with those from state.
+// The ids of the model and items are kept, since state may come from before an i3 restart.
+func (f *FlexModel) restore(state FlexModelState) error {
+	if len(state.Items) != len(f.items) {
+		return fmt.Errorf("state has %d items, model has %d", len(state.Items), len(f.items))
+	}
+	sum := Size(0)
+	pinned := 0
+	for _, item := range state.Items {
+		sum = sum + item.Current
+		if item.Pinned {
+			pinned++
+		}
+	}
+	if pinned > 1 {
+		return fmt.Errorf("state has %d pinned items, expected at most one", pinned)
+	}
+	if sum != normal {
+		return fmt.Errorf("state sizes add up to %d, expected %d", sum, normal)
+	}
+	constraints := make([]MinItemConstraint, 0, len(state.Constraints))
+	for _, c := range state.Constraints {
+		if c.Item < 0 || c.Item >= len(f.items) {
+			return fmt.Errorf("constraint refers to item %d out of %d", c.Item, len(f.items))
+		}
+		switch c.Kind {
+		case flexConstraintKind:
+			constraints = append(constraints, FlexItemMinFlexConstraint{f.items[c.Item], c.Item})
+		case unflexConstraintKind:
+			constraints = append(constraints, FlexItemMinUnflexConstraint{f.items[c.Item], c.Item})
+		default:
+			return fmt.Errorf("unknown constraint kind %q", c.Kind)
+		}
+	}
+
+	for _, i := range state.Recent {
+		if i < 0 || i >= len(f.items) {
+			return fmt.Errorf("focus history refers to item %d out of %d", i, len(f.items))
+		}
+	}
+	var sizing Sizing
+	if state.Sizing != "" {
+		var err error
+		if sizing, err = lookupSizing(state.Sizing); err != nil {
+			return err
+		}
+	}
+
+	for i, item := range state.Items {
+		f.items[i].current = item.Current
+		f.items[i].softMinFlex = item.SoftMinFlex
+		f.items[i].softMinUnflex = item.SoftMinUnflex
+		f.items[i].flexTarget = item.FlexTarget
+		f.items[i].pinned = item.Pinned
+		f.items[i].shrunk = item.Shrunk
+	}
+	f.constraints = constraints
+	f.sizing = sizing
+	f.recent = append([]int(nil), state.Recent...)
+	return nil
+}
+
+// Checks whether state describes model, in which case it can be restored into it.
+//
+// Items match by con id, or by window id if i3 was restarted in between.
+// Container items are matched through idMap, which maps con ids from the state
+// to con ids of models which were already matched.
+func (state FlexModelState) matches(model *FlexModel, idMap map[i3.NodeID]i3.NodeID) bool {
+	if state.Direction != model.direction || len(state.Items) != len(model.items) {
+		return false
+	}
+	for i, saved := range state.Items {
+		item := model.items[i]
+		switch {
+		case saved.ID == item.id:
+		case saved.Window != 0 && saved.Window == item.window:
+		case saved.Window == 0 && idMap[saved.ID] == item.id:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Re-attaches previously saved states to the current models, and returns the models which were restored.
+//
+// Models are matched innermost first, since a container item can only be
+// recognized after the model for that container has been matched.
+func (f *FlexModels) Restore(states []FlexModelState) []*FlexModel {
+	idMap := make(map[i3.NodeID]i3.NodeID)
+	restored := make([]*FlexModel, 0, len(states))
+	restoredIds := make(map[i3.NodeID]bool)
+
+	pending := states
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		unmatched := make([]FlexModelState, 0, len(pending))
+		for _, state := range pending {
+			var match *FlexModel
+			for _, model := range f.models {
+				if !restoredIds[model.id] && state.matches(model, idMap) {
+					match = model
+					break
+				}
+			}
+			if match == nil {
+				unmatched = append(unmatched, state)
+				continue
+			}
+			progress = true
+			idMap[state.ID] = match.id
+			restoredIds[match.id] = true
+			if err := match.restore(state); err != nil {
+				logWarn(logModel, "Not restoring model [%d] from state: %s", match.id, err.Error())
+				continue
+			}
+			restored = append(restored, match)
+		}
+		pending = unmatched
+	}
+	logInfo(logModel, "Restored %d of %d saved models", len(restored), len(states))
+	return restored
+}
+
+// Snapshots all models, ordered by id so the output is stable
+func (f *FlexModels) State() []FlexModelState {
+	states := make([]FlexModelState, 0, len(f.models))
+	for _, model := range f.models {
+		states = append(states, model.State())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// A splith of a window and a splitv container, which holds two windows
+func nestedUpdates(offset int) []FlexUpdate {
+	id := func(n int) i3.NodeID { return i3.NodeID(n + offset) }
+	return []FlexUpdate{
+		{ExternalId: id(1), Direction: Horizontal, Items: []FlexItemUpdate{
+			{ExternalId: id(2), Window: 100, Size: 700},
+			{ExternalId: id(3), Size: 300},
+		}},
+		{ExternalId: id(3), Direction: Vertical, Items: []FlexItemUpdate{
+			{ExternalId: id(4), Window: 101, Size: 500},
+			{ExternalId: id(5), Window: 102, Size: 500},
+		}},
+	}
+}
+
+func TestRestoreAfterRestart(t *testing.T) {
+	before := initFlexModels(defaultGlobalSizings())
+	before.Updates(nestedUpdates(0), true)
+	before.models[3].items[1].softMinUnflex = 200
+	before.models[3].putConstraint(1)
+	states := before.State()
+
+	// Con ids change on restart, window ids don't
+	after := initFlexModels(defaultGlobalSizings())
+	after.Updates(nestedUpdates(10), true)
+	restored := after.Restore(states)
+	if len(restored) != 2 {
+		t.Fatalf("expected both models to be restored, got %d", len(restored))
+	}
+	model := after.models[13]
+	if model.items[1].softMinUnflex != 200 {
+		t.Errorf("override not restored: %+v", model.items[1])
+	}
+	if len(model.constraints) != 1 || model.constraints[0].ItemIndex() != 1 {
+		t.Errorf("constraints not restored: %+v", model.constraints)
+	}
+}
+
+func TestRestoreMismatch(t *testing.T) {
+	before := initFlexModels(defaultGlobalSizings())
+	before.Updates(nestedUpdates(0), true)
+	states := before.State()
+
+	updates := nestedUpdates(10)
+	updates[1].Items[1].Window = 103 // A different window was opened
+	after := initFlexModels(defaultGlobalSizings())
+	after.Updates(updates, true)
+	if restored := after.Restore(states); len(restored) != 0 {
+		t.Errorf("expected nothing to be restored, got %d", len(restored))
+	}
+}
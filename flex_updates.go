@@ -6,9 +6,25 @@ type FlexUpdate struct {
 	ExternalId i3.NodeID
 	Direction  FlexDirection
 	Items      []FlexItemUpdate
+	Workspace  string // Names of the workspace and output the container is on
+	Output     string
 }
 
 type FlexItemUpdate struct {
 	ExternalId i3.NodeID
+	Window     int64 // X11 window id, 0 for containers. Unlike ExternalId, it survives an i3 restart
 	Size       int
+	Members    []i3.NodeID // For tabbed and stacked containers, the ids of everything within them
+	Properties i3.WindowProperties
+}
+
+func memberSet(members []i3.NodeID) map[i3.NodeID]bool {
+	if len(members) == 0 {
+		return nil
+	}
+	set := make(map[i3.NodeID]bool, len(members))
+	for _, id := range members {
+		set[id] = true
+	}
+	return set
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// The gaps from the i3 config, which take space away from containers.
+//
+// i3 sizes the children of a split container by their percent of the container,
+// then insets every window by half the inner gap on each side,
+// and workspaces by the outer gaps.
+// Borders and title bars are drawn within a child's rect, so they need no accounting here.
+type i3Gaps struct {
+	inner                    int64
+	top, right, bottom, left int64 // Outer gaps
+	smart                    bool  // No gaps when a workspace has a single container
+}
+
+// Reads the global gaps from the text of the i3 config.
+// Per-workspace gaps aren't supported, and the overhead falls back to what's measured for those.
+func parseGaps(config string) i3Gaps {
+	var gaps i3Gaps
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "smart_gaps" {
+			gaps.smart = fields[1] == "on" || fields[1] == "true" || fields[1] == "yes"
+			continue
+		}
+		if len(fields) != 3 || fields[0] != "gaps" {
+			continue
+		}
+		size, err := strconv.ParseInt(strings.TrimSuffix(fields[2], "px"), 10, 64)
+		if err != nil {
+			logWarn(logTraverser, "Ignoring gaps with invalid size: %s", line)
+			continue
+		}
+		switch fields[1] {
+		case "inner":
+			gaps.inner = size
+		case "outer":
+			gaps.top, gaps.right, gaps.bottom, gaps.left = size, size, size, size
+		case "horizontal":
+			gaps.left, gaps.right = size, size
+		case "vertical":
+			gaps.top, gaps.bottom = size, size
+		case "top":
+			gaps.top = size
+		case "right":
+			gaps.right = size
+		case "bottom":
+			gaps.bottom = size
+		case "left":
+			gaps.left = size
+		}
+	}
+	return gaps
+}
+
+func loadGaps() (i3Gaps, error) {
+	config, err := i3.GetConfig()
+	if err != nil {
+		return i3Gaps{}, err
+	}
+	return parseGaps(config.Config), nil
+}
+
+// The overhead of the container along its split axis: outer gaps at the edges of a workspace
+func (g i3Gaps) containerOverhead(node *i3.Node) int64 {
+	if node.Type != i3.WorkspaceNode || (g.smart && len(node.Nodes) < 2) {
+		return 0
+	}
+	if node.Layout == i3.SplitH {
+		return g.left + g.right
+	}
+	return g.top + g.bottom
+}
+
+// The overhead of a single child along the split axis: the inner gap, for children which get inset.
+// Split containers aren't inset themselves, only the windows within them.
+func (g i3Gaps) childOverhead(node *i3.Node, siblings int) int64 {
+	if isSplitContainer(node) || (g.smart && siblings < 2) {
+		return 0
+	}
+	return g.inner
+}
+
+// Returns the length of each child along the split axis, including its share of the gaps,
+// so that the lengths correspond to the children's percents of the container.
+//
+// If the overhead measured from the rects doesn't match what the gaps account for
+// (e.g. per-workspace gaps), the difference is spread evenly over the children.
+func (g i3Gaps) childSizes(node *i3.Node, sizer func(node *i3.Node) int64) []int64 {
+	sizes := make([]int64, 0, len(node.Nodes))
+	measured := sizer(node)
+	expected := g.containerOverhead(node)
+	for _, child := range node.Nodes {
+		overhead := g.childOverhead(child, len(node.Nodes))
+		sizes = append(sizes, sizer(child)+overhead)
+		measured = measured - sizer(child)
+		expected = expected + overhead
+	}
+
+	if diff := measured - expected; diff != 0 && len(sizes) > 0 {
+		logDebug(logTraverser, "Gaps did not account for the overhead of [%d]. difference=[%d]", node.ID, diff)
+		share := diff / int64(len(sizes))
+		for i := range sizes {
+			sizes[i] = sizes[i] + share
+		}
+	}
+	return sizes
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"go.i3wm.org/i3/v4"
+)
+
+func TestParseGaps(t *testing.T) {
+	gaps := parseGaps("font pango:monospace 8\ngaps inner 22\ngaps outer 35px\n  gaps left 10\nworkspace 2 gaps inner 0\n")
+	if gaps.inner != 22 || gaps.top != 35 || gaps.right != 35 || gaps.left != 10 {
+		t.Errorf("unexpected gaps %+v", gaps)
+	}
+}
+
+func TestChildSizesIncludeGaps(t *testing.T) {
+	gaps := i3Gaps{inner: 22, left: 46, right: 46}
+	width := func(n *i3.Node) int64 { return n.Rect.Width }
+	// 3 windows at 50%, 25% and 25% of 1920, minus 92 of outer gaps, each inset by the inner gap
+	workspace := &i3.Node{
+		Type:   i3.WorkspaceNode,
+		Layout: i3.SplitH,
+		Rect:   i3.Rect{Width: 1920},
+		Nodes: []*i3.Node{
+			{Window: 1, Rect: i3.Rect{Width: 914 - 22}},
+			{Window: 2, Rect: i3.Rect{Width: 457 - 22}},
+			{Window: 3, Rect: i3.Rect{Width: 457 - 22}},
+		},
+	}
+	sizes := gaps.childSizes(workspace, width)
+	if sizes[0] != 914 || sizes[1] != 457 || sizes[2] != 457 {
+		t.Errorf("unexpected sizes %v", sizes)
+	}
+
+	// Unaccounted overhead is spread evenly
+	sizes = i3Gaps{}.childSizes(workspace, width)
+	if sizes[0] != 944 || sizes[1] != 487 || sizes[2] != 487 {
+		t.Errorf("unexpected sizes without gaps %v", sizes)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/washtubs/i3-flex
 
 go 1.15
 
-require go.i3wm.org/i3/v4 v4.18.0
+require (
+	github.com/BurntSushi/toml v0.3.1
+	go.i3wm.org/i3/v4 v4.18.0
+)
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"sort"
 	"strings"
 
@@ -17,63 +16,130 @@ func (b ByCurrent) Len() int               { return len(b) }
 func (b ByCurrent) Less(i int, j int) bool { return b[i].current < b[j].current }
 func (b ByCurrent) Swap(i int, j int)      { b[i], b[j] = b[j], b[i] }
 
-func (r *i3FlexRenderer) Render(models []*FlexModel) {
-	// Batch all resize commands
-	sb := strings.Builder{}
+// A single resize, which is one of the sub-commands in a chained i3 command
+type resizeCommand struct {
+	id  i3.NodeID
+	cmd string
+}
+
+// A resize i3 rejected
+type resizeFailure struct {
+	resizeCommand
+	reason string
+}
+
+// Returned by Render when some of the resizes were rejected by i3
+type RenderError struct {
+	failures []resizeFailure
+}
+
+func (e *RenderError) Error() string {
+	reasons := make([]string, 0, len(e.failures))
+	for _, f := range e.failures {
+		reasons = append(reasons, fmt.Sprintf("con_id=%d (%s): %s", f.id, f.cmd, f.reason))
+	}
+	return fmt.Sprintf("%d resize(s) failed: %s", len(e.failures), strings.Join(reasons, "; "))
+}
+
+// Renders all models with a single chained i3 command
+func (r *i3FlexRenderer) Render(models []*FlexModel) error {
+	cmds := make([]resizeCommand, 0)
 	for _, model := range models {
-		r.render(model, &sb)
+		cmds = append(cmds, resizeCommands(model)...)
+	}
+	if len(cmds) == 0 {
+		return nil
 	}
 
-	//log.Printf("i3-msg %s", sb.String())
-	//_, err := i3.RunCommand(sb.String())
-	//if err != nil {
-	//log.Printf("Error rendering: %s", err.Error())
-	//}
+	chain := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		chain = append(chain, cmd.cmd)
+	}
+	chained := strings.Join(chain, "; ")
+	logDebug(logRenderer, "i3-msg %s", chained)
+	results, err := i3.RunCommand(chained)
+	if err != nil && !i3.IsUnsuccessful(err) {
+		return err
+	}
+	return checkResults(cmds, results)
 }
 
-func (r *i3FlexRenderer) render(model *FlexModel, cmd *strings.Builder) {
+// Matches up i3's results with the sub-commands that produced them
+func checkResults(cmds []resizeCommand, results []i3.CommandResult) error {
+	failures := make([]resizeFailure, 0)
+	for i, cmd := range cmds {
+		if i >= len(results) {
+			failures = append(failures, resizeFailure{cmd, "no result from i3"})
+			continue
+		}
+		if !results[i].Success {
+			failures = append(failures, resizeFailure{cmd, results[i].Error})
+		}
+	}
+	if len(failures) > 0 {
+		return &RenderError{failures}
+	}
+	return nil
+}
+
+// Builds the resize commands for the model, largest item first,
+// so growing it isn't limited by the items that are about to shrink.
+func resizeCommands(model *FlexModel) []resizeCommand {
 	resizeDirection := "height"
 	if model.direction == Horizontal {
 		resizeDirection = "width"
 	}
 
 	for _, v := range model.items {
-		log.Printf("item current %d", v.current)
+		logTrace(logRenderer, "item current %d", v.current)
+	}
+	byCurrent, ppts := pptsByCurrent(model)
+
+	cmds := make([]resizeCommand, 0, len(byCurrent))
+	for i, item := range byCurrent {
+		cmds = append(cmds, resizeCommand{
+			id:  item.id,
+			cmd: fmt.Sprintf("[con_id=%d] resize set %s %d ppt", item.id, resizeDirection, ppts[i]),
+		})
 	}
+	return cmds
+}
+
+// Returns the model's items largest first, along with the percents they render as
+func pptsByCurrent(model *FlexModel) ([]*FlexItem, []int) {
 	byCurrent := make(ByCurrent, len(model.items))
 	copy(byCurrent, model.items)
 	sort.Sort(sort.Reverse(byCurrent))
 
-	// Rescale from normal to percents (100 scale)
-	scaled := make([]*int, len(byCurrent))
+	sizes := make([]Size, len(byCurrent))
 	for i, item := range byCurrent {
-		scaled[i] = new(int)
-		*scaled[i] = int(item.current)
+		sizes[i] = item.current
 	}
-	checkScale(scaled, normal)
-	rescale(scaled, normal, 100)
+	return byCurrent, toPpt(sizes)
+}
 
-	retryCmds := make([]string, 0)
-	for i, item := range byCurrent {
-		//cmd.WriteString(fmt.Sprintf("[con_id=%d] resize set %s %d ppt; ", item.id, resizeDirection, *scaled[i]))
-		cmd := fmt.Sprintf("[con_id=%d] resize set %s %d ppt; ", item.id, resizeDirection, *scaled[i])
-		log.Printf("i3-msg %s", cmd)
-		_, err := i3.RunCommand(cmd)
-		if err != nil {
-			retryCmds = append(retryCmds, cmd)
-		}
+// Converts sizes from the normal scale to percents, which is what i3 stores and `resize set ... ppt` sets.
+// Rounds so the percents add up to 100, with the leftover points going to the sizes which lost the most to rounding.
+func toPpt(sizes []Size) []int {
+	ppts := make([]int, len(sizes))
+	remainders := make([]int, len(sizes))
+	total := 0
+	for i, size := range sizes {
+		ppts[i] = int(size) * 100 / normal
+		remainders[i] = int(size) * 100 % normal
+		total = total + ppts[i]
+	}
+	byRemainder := make([]int, len(sizes))
+	for i := range byRemainder {
+		byRemainder[i] = i
 	}
-	passes := 3
-	for len(retryCmds) > 0 && passes > 0 {
-		passes = passes - 1
-		retryCmdsTmp := make([]string, 0)
-		for _, cmd := range retryCmds {
-			_, err := i3.RunCommand(cmd)
-			if err != nil {
-				retryCmdsTmp = append(retryCmdsTmp, cmd)
-				log.Printf("Error rendering: %s", err.Error())
-			}
+	sort.SliceStable(byRemainder, func(i, j int) bool { return remainders[byRemainder[i]] > remainders[byRemainder[j]] })
+	for _, i := range byRemainder {
+		if total >= 100 {
+			break
 		}
-		retryCmds = retryCmdsTmp
+		ppts[i]++
+		total++
 	}
+	return ppts
 }
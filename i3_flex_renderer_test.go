@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"go.i3wm.org/i3/v4"
+)
+
+func TestToPpt(t *testing.T) {
+	ppts := toPpt([]Size{619, 191, 190})
+	if ppts[0] != 62 || ppts[1] != 19 || ppts[2] != 19 {
+		t.Errorf("unexpected ppts %v", ppts)
+	}
+}
+
+func TestCheckResults(t *testing.T) {
+	cmds := []resizeCommand{
+		{5, "[con_id=5] resize set width 62 ppt"},
+		{6, "[con_id=6] resize set width 38 ppt"},
+	}
+	for _, test := range []struct {
+		name    string
+		results []i3.CommandResult
+		failed  map[i3.NodeID]string
+	}{
+		{"all succeeded", []i3.CommandResult{{Success: true}, {Success: true}}, nil},
+		{"partial failure", []i3.CommandResult{{Success: true}, {Success: false, Error: "no such container"}},
+			map[i3.NodeID]string{6: "no such container"}},
+		{"all failed", []i3.CommandResult{{Error: "a"}, {Error: "b"}},
+			map[i3.NodeID]string{5: "a", 6: "b"}},
+		{"fewer results than commands", []i3.CommandResult{{Success: true}},
+			map[i3.NodeID]string{6: "no result from i3"}},
+		{"no results", nil,
+			map[i3.NodeID]string{5: "no result from i3", 6: "no result from i3"}},
+	} {
+		err := checkResults(cmds, test.results)
+		if test.failed == nil {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %s", test.name, err)
+			}
+			continue
+		}
+		renderErr, ok := err.(*RenderError)
+		if !ok {
+			t.Errorf("%s: expected a RenderError, got %v", test.name, err)
+			continue
+		}
+		if len(renderErr.failures) != len(test.failed) {
+			t.Errorf("%s: expected failures for %v, got %s", test.name, test.failed, renderErr)
+			continue
+		}
+		for _, failure := range renderErr.failures {
+			if reason, ok := test.failed[failure.id]; !ok || reason != failure.reason {
+				t.Errorf("%s: unexpected failure of con_id=%d: %s", test.name, failure.id, failure.reason)
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 
 	"go.i3wm.org/i3/v4"
 )
@@ -50,6 +49,41 @@ func (t *Traverser) depthFirstTraversal(onPush TraverserHook, onPop TraverserHoo
 	}
 }
 
+// Returns the nodes from root down to the node with the id, or nil if it's not in the tree
+func findPath(root *i3.Node, id i3.NodeID) []*i3.Node {
+	if root.ID == id {
+		return []*i3.Node{root}
+	}
+	for _, child := range root.Nodes {
+		if path := findPath(child, id); path != nil {
+			return append([]*i3.Node{root}, path...)
+		}
+	}
+	return nil
+}
+
+func findFocused(n *i3.Node) *i3.Node {
+	if n.Focused {
+		return n
+	}
+	for _, child := range n.Nodes {
+		if focused := findFocused(child); focused != nil {
+			return focused
+		}
+	}
+	return nil
+}
+
+// Returns the ids of every tiling container below n
+func descendantIDs(n *i3.Node) []i3.NodeID {
+	ids := make([]i3.NodeID, 0)
+	for _, child := range n.Nodes {
+		ids = append(ids, child.ID)
+		ids = append(ids, descendantIDs(child)...)
+	}
+	return ids
+}
+
 func createTraverser(node *i3.Node) *Traverser {
 	path := make([]*i3.Node, 0)
 	pathPositions := make([]int, 0) // represents the first unprocessed value for the path element
@@ -85,62 +119,82 @@ func simplePrint(t *Traverser) {
 
 }
 
-func fullUpdate(t *Traverser) []FlexUpdate {
+func fullUpdate(t *Traverser, gaps i3Gaps) []FlexUpdate {
 
 	updates := make([]FlexUpdate, 0)
 
 	onPop := func(path []*i3.Node, node *i3.Node) {}
 	onLeaf := func(path []*i3.Node, node *i3.Node) {}
 	onPush := func(path []*i3.Node, node *i3.Node) {
-		if !isSplitContainer(node) {
-			return
-		}
-		for _, n := range node.Nodes {
-			if n.Type == i3.WorkspaceNode {
-				// No workspace containers
-				return
-			}
-		}
-		var (
-			sizer func(node *i3.Node) int
-			dir   FlexDirection
-		)
-		if node.Layout == i3.SplitH {
-			sizer = func(node *i3.Node) int { return int(node.Rect.Width) } // TODO checked conversion?
-			dir = Horizontal
-		} else { // SplitV
-			sizer = func(node *i3.Node) int { return int(node.Rect.Height) } // TODO checked conversion?
-			dir = Vertical
-		}
-		sum := sizer(node)
-		//log.Printf("PATH %+v", path)
-		//log.Printf("NODE %+v", node)
-		//log.Printf("SUM dir=[%s] children=[%d] sum=[%d]", dir, len(node.Nodes), sum)
-		items := make([]FlexItemUpdate, 0, len(node.Nodes))
-		for _, n := range node.Nodes {
-			size := sizer(n)
-			//log.Printf("CHILD NODE %+v", n)
-			//log.Printf("size %d", size)
-			sum = sum - size
-			items = append(items, FlexItemUpdate{
-				ExternalId: n.ID,
-				Size:       size,
-			})
+		if update, ok := containerUpdate(path, gaps); ok {
+			updates = append(updates, update)
 		}
-		// TODO: This is a result of my configs and gaps I suspect.
-		// I need to understand why the sum isn't 0
-		expectedDifference := 114 + 22*(len(node.Nodes)-1)
-		if sum != expectedDifference {
-			log.Print(fmt.Sprintf("Sizes did not add up. difference=[%d]", sum))
-		}
-		updates = append(updates, FlexUpdate{
-			ExternalId: node.ID,
-			Items:      items,
-			Direction:  dir,
-		})
 	}
 
 	t.depthFirstTraversal(onPush, onPop, onLeaf)
 
 	return updates
 }
+
+// Returns the updates for the split containers from the root down to the node with the id.
+// These are the only models which can be affected by an event for that node.
+func pathUpdates(root *i3.Node, id i3.NodeID, gaps i3Gaps) []FlexUpdate {
+	updates := make([]FlexUpdate, 0)
+	path := findPath(root, id)
+	for i := range path {
+		if update, ok := containerUpdate(path[:i+1], gaps); ok {
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+// Builds the update for the container at the end of path, if it's one that should be modelled
+func containerUpdate(path []*i3.Node, gaps i3Gaps) (FlexUpdate, bool) {
+	node := path[len(path)-1]
+	if !isSplitContainer(node) || len(node.Nodes) == 0 {
+		return FlexUpdate{}, false
+	}
+	for _, n := range node.Nodes {
+		if n.Type == i3.WorkspaceNode {
+			// No workspace containers
+			return FlexUpdate{}, false
+		}
+	}
+	var (
+		sizer func(node *i3.Node) int64
+		dir   FlexDirection
+	)
+	if node.Layout == i3.SplitH {
+		sizer = func(node *i3.Node) int64 { return node.Rect.Width }
+		dir = Horizontal
+	} else { // SplitV
+		sizer = func(node *i3.Node) int64 { return node.Rect.Height }
+		dir = Vertical
+	}
+	//log.Printf("NODE %+v", node)
+	sizes := gaps.childSizes(node, sizer)
+	items := make([]FlexItemUpdate, 0, len(node.Nodes))
+	for i, n := range node.Nodes {
+		//log.Printf("CHILD NODE %+v", n)
+		//log.Printf("size %d", sizes[i])
+		item := FlexItemUpdate{
+			ExternalId: n.ID,
+			Window:     n.Window,
+			Size:       int(sizes[i]), // TODO checked conversion?
+			Properties: n.WindowProperties,
+		}
+		if isTabbedContainer(n) {
+			item.Members = descendantIDs(n)
+		}
+		items = append(items, item)
+	}
+	workspace, output := locate(path)
+	return FlexUpdate{
+		ExternalId: node.ID,
+		Items:      items,
+		Direction:  dir,
+		Workspace:  workspace,
+		Output:     output,
+	}, true
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// How much gets logged, from least to most
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo  // What the daemon did, a line per event or model
+	levelDebug // How it got there
+	levelTrace // Every number it touched along the way
+)
+
+var levelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+func (l logLevel) String() string { return levelNames[l] }
+
+func parseLevel(name string) (logLevel, error) {
+	for level, levelName := range levelNames {
+		if name == levelName {
+			return logLevel(level), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected one of: %s", name, strings.Join(levelNames, ", "))
+}
+
+// The subsystems whose levels can be set on their own
+type logCategory string
+
+const (
+	logTraverser logCategory = "traverser" // Reading the i3 tree and config into updates
+	logModel     logCategory = "model"     // Flexing, resizes by the user and the constraints learned from them
+	logRebalance logCategory = "rebalance" // Rescaling and rebalancing sizes
+	logRenderer  logCategory = "renderer"  // Resizing in i3
+	logEvents    logCategory = "events"    // i3 events, control commands, and the daemon itself
+)
+
+var logCategories = []logCategory{logTraverser, logModel, logRebalance, logRenderer, logEvents}
+
+// The level for each category, falling back to a default
+type logLevels struct {
+	level      logLevel
+	categories map[logCategory]logLevel
+}
+
+// Parses levels given as "level", "category=level", or a comma separated mix of both
+func parseLevels(spec string, levels logLevels) (logLevels, error) {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq < 0 {
+			level, err := parseLevel(part)
+			if err != nil {
+				return levels, err
+			}
+			levels.level = level
+			continue
+		}
+		var err error
+		if levels, err = levels.with(part[:eq], part[eq+1:]); err != nil {
+			return levels, err
+		}
+	}
+	return levels, nil
+}
+
+// Returns a copy of the levels with the category's level set
+func (l logLevels) with(category string, levelName string) (logLevels, error) {
+	known := false
+	for _, c := range logCategories {
+		known = known || string(c) == category
+	}
+	if !known {
+		names := make([]string, 0, len(logCategories))
+		for _, c := range logCategories {
+			names = append(names, string(c))
+		}
+		sort.Strings(names)
+		return l, fmt.Errorf("unknown log category %q, expected one of: %s", category, strings.Join(names, ", "))
+	}
+	level, err := parseLevel(levelName)
+	if err != nil {
+		return l, fmt.Errorf("%s: %w", category, err)
+	}
+	categories := make(map[logCategory]logLevel, len(l.categories)+1)
+	for c, level := range l.categories {
+		categories[c] = level
+	}
+	categories[logCategory(category)] = level
+	l.categories = categories
+	return l, nil
+}
+
+func (l logLevels) enabled(category logCategory, level logLevel) bool {
+	if categoryLevel, ok := l.categories[category]; ok {
+		return level <= categoryLevel
+	}
+	return level <= l.level
+}
+
+// The levels in force. Only set on startup, before anything logs concurrently
+var logging = logLevels{level: levelInfo}
+
+func logf(category logCategory, level logLevel, format string, args ...interface{}) {
+	if !logging.enabled(category, level) {
+		return
+	}
+	log.Printf("%-5s %-9s %s", level, category, fmt.Sprintf(format, args...))
+}
+
+func logError(category logCategory, format string, args ...interface{}) {
+	logf(category, levelError, format, args...)
+}
+
+func logWarn(category logCategory, format string, args ...interface{}) {
+	logf(category, levelWarn, format, args...)
+}
+
+func logInfo(category logCategory, format string, args ...interface{}) {
+	logf(category, levelInfo, format, args...)
+}
+
+func logDebug(category logCategory, format string, args ...interface{}) {
+	logf(category, levelDebug, format, args...)
+}
+
+func logTrace(category logCategory, format string, args ...interface{}) {
+	logf(category, levelTrace, format, args...)
+}
+
+// A log file which is rotated once it grows past maxSize bytes:
+// path is renamed to path.1, path.1 to path.2 and so on, keeping up to backups of them.
+type rotatingFile struct {
+	path    string
+	maxSize int64 // 0 never rotates
+	backups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size = r.size + int64(n)
+	return n, err
+}
+
+// Must be called holding r.mu
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if r.backups == 0 {
+		os.Remove(r.path)
+	}
+	for i := r.backups; i > 0; i-- {
+		from := r.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+		err := os.Rename(from, fmt.Sprintf("%s.%d", r.path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
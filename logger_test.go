@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := parseLevels("warn, model=trace,renderer=error", logLevels{level: levelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !levels.enabled(logModel, levelTrace) || levels.enabled(logRenderer, levelWarn) {
+		t.Errorf("category levels not applied: %+v", levels)
+	}
+	if !levels.enabled(logEvents, levelWarn) || levels.enabled(logEvents, levelInfo) {
+		t.Errorf("default level not applied: %+v", levels)
+	}
+	for _, spec := range []string{"loud", "windows=debug", "model=loud"} {
+		if _, err := parseLevels(spec, levels); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i3-flex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "i3-flex.log")
+	file, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	for name, expected := range map[string]string{
+		"i3-flex.log":   "fourth\n",
+		"i3-flex.log.1": "third\n",
+		"i3-flex.log.2": "second\n",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != expected {
+			t.Errorf("expected %s to hold %q, got %q", name, expected, contents)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups to be kept")
+	}
+}
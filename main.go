@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"go.i3wm.org/i3/v4"
 )
@@ -15,7 +18,13 @@ func isWindow(n *i3.Node) bool {
 }
 
 func isSplitContainer(n *i3.Node) bool {
-	return !isWindow(n) && n.Layout == i3.SplitH || n.Layout == i3.SplitV
+	return !isWindow(n) && (n.Layout == i3.SplitH || n.Layout == i3.SplitV)
+}
+
+// Tabbed and stacked containers only show one child at a time,
+// so they take part in flexing as a single item
+func isTabbedContainer(n *i3.Node) bool {
+	return !isWindow(n) && (n.Layout == i3.Tabbed || n.Layout == i3.Stacked)
 }
 
 func dashes(n int) string {
@@ -39,45 +48,107 @@ func doPrint(tree i3.Tree) {
 
 }
 
-func testRender() {
-}
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := flags.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	statePath := flags.String("state", defaultStatePath(), "path to the state file, empty to disable persistence")
+	dryRun := flags.Bool("dry-run", false, "print the resize commands instead of running them. Implies -state=\"\"")
+	logSpec := flags.String("log", "", "log levels, overriding the config: a level, category=level, or a comma separated mix")
+	logFile := flags.String("log-file", "", "file to log to instead of stderr, overriding the config")
+	flags.Parse(args)
+
+	config, err := loadConfigFlag(*configPath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := config.useLogLevels(*logSpec); err != nil {
+		log.Fatal(err.Error())
+	}
+	if *logFile == "" {
+		*logFile = config.Log.File
+	}
+	if *logFile != "" {
+		file, err := config.Log.openFile(*logFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+	}
 
-func serve() {
-	fm := initFlexModels()
-	fm.RegisterRenderer(&i3FlexRenderer{})
+	fm := initFlexModels(config.sizings)
+	fm.RegisterRenderer(config.Animation.renderer(&i3FlexRenderer{}))
+	fm.SetRules(config.rules)
+	fm.SetAreas(config.areas)
+	fm.SetPropagation(config.propagation)
+	if *dryRun {
+		// Sizes which were never rendered mustn't be persisted, and restored later
+		*statePath = ""
+		fm.RegisterRenderer(&dryRunRenderer{os.Stdout})
+		fm.IgnoreResizes(true)
+	}
+	d := newDaemon(fm, *statePath)
 
-	rcv := i3.Subscribe(i3.WindowEventType)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
+	ctlPath := ctlSocketPath()
+	l, err := listenCtl(ctlPath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer l.Close()
+	go d.serveCtl(l)
+	logInfo(logEvents, "Listening for control commands on %s", ctlPath)
+
+	d.mu.Lock()
+	d.reloadGaps()
+	if err := d.sync(); err != nil {
+		logError(logEvents, "Error syncing with i3: %s", err.Error())
+	} else if err := d.restore(); err != nil {
+		logError(logEvents, "Error restoring state: %s", err.Error())
+	}
+	d.mu.Unlock()
+
+	if config.Daemon.ResyncInterval > 0 {
+		go d.resyncEvery(time.Duration(config.Daemon.ResyncInterval) * time.Second)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logInfo(logEvents, "Got %s, shutting down", sig)
+		d.shutdown()
+		l.Close()
+		os.Exit(0)
+	}()
+
+	rcv := i3.Subscribe(subscribedEvents...)
+	events := make(chan i3.Event, 64)
 	go func() {
 		for rcv.Next() {
-			event := rcv.Event()
-			ev, ok := event.(*i3.WindowEvent)
-			if !ok {
-				log.Printf("Unexpected event type: %+v, %+v", ev, event)
-				continue
-			}
-			if ev.Change == "focus" {
-				log.Printf("Got focus")
-			}
-			// Do updates
-			tree, err := i3.GetTree()
-			if err != nil {
-				panic(err.Error())
-			}
-			t := createTraverser(tree.Root)
-			updates := fullUpdate(t)
-			fm.Updates(updates, true)
-			fm.OnFocus(ev.Container.ID)
+			events <- rcv.Event()
 		}
+		close(events)
+	}()
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		d.handleEvents(events, time.Duration(config.Daemon.CoalesceWindow)*time.Millisecond)
 		err := rcv.Close()
 		if err != nil {
-			log.Printf("Error closing i3 receiver: %s", err.Error())
+			logError(logEvents, "Error closing i3 receiver: %s", err.Error())
 		}
 		wg.Done()
 	}()
 
 	wg.Wait()
+	d.shutdown()
+}
+
+// i3 exports I3SOCK to everything it starts, and mock-i3 asks for it to be set,
+// so prefer it over asking the i3 binary for its socket.
+func useI3SockEnv() {
+	if path := os.Getenv("I3SOCK"); path != "" {
+		i3.SocketPathHook = func() (string, error) { return path, nil }
+	}
 }
 
 func main() {
@@ -88,18 +159,27 @@ func main() {
 	if commandStr == "" {
 		log.Fatal("No command") // TODO: list commands
 	}
-	//cmdArgs := globals.Args()[1:]
+	cmdArgs := globals.Args()[1:]
+	useI3SockEnv()
 	switch commandStr {
 	case "serve":
-		serve()
+		serve(cmdArgs)
+	case "mock-i3":
+		if err := runMockI3(cmdArgs); err != nil {
+			log.Fatal(err.Error())
+		}
+	case "ctl":
+		if err := runCtl(cmdArgs); err != nil {
+			log.Fatal(err.Error())
+		}
+	case "watch":
+		if err := runWatch(cmdArgs); err != nil {
+			log.Fatal(err.Error())
+		}
 	case "debug":
-		testRender()
-		tree, err := i3.GetTree()
-		if err != nil {
-			panic(err.Error())
+		if err := runDebug(cmdArgs); err != nil {
+			log.Fatal(err.Error())
 		}
-		t := createTraverser(tree.Root)
-		simplePrint(t)
 	}
 
 	// Simple:
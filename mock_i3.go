@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// A fake i3 speaking enough of the IPC protocol for the daemon to run against it headlessly.
+//
+// It serves a tree loaded from a fixture (e.g. saved with `i3-msg -t get_tree`),
+// applies `resize set ... ppt` and `focus` commands to it,
+// and sends the corresponding events to subscribers.
+
+const mockMagic = "i3-ipc"
+
+// https://i3wm.org/docs/ipc.html#_sending_messages_to_i3
+const (
+	mockRunCommand uint32 = 0
+	mockSubscribe  uint32 = 2
+	mockGetTree    uint32 = 4
+	mockGetVersion uint32 = 7
+	mockGetConfig  uint32 = 9
+	mockSendTick   uint32 = 10
+	mockSync       uint32 = 11
+)
+
+// Event message types have the highest bit set
+var mockEventTypes = map[i3.EventType]uint32{
+	i3.WorkspaceEventType:       0,
+	i3.OutputEventType:          1,
+	i3.ModeEventType:            2,
+	i3.WindowEventType:          3,
+	i3.BarconfigUpdateEventType: 4,
+	i3.BindingEventType:         5,
+	i3.ShutdownEventType:        6,
+	i3.TickEventType:            7,
+}
+
+type mockI3 struct {
+	mu          sync.Mutex
+	tree        *i3.Node
+	config      string
+	subscribers []*mockConn
+}
+
+type mockConn struct {
+	net.Conn
+	wmu    sync.Mutex // Replies and events may be written concurrently
+	events map[i3.EventType]bool
+}
+
+type mockCommandResult struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newMockI3(tree *i3.Node, config string) *mockI3 {
+	m := &mockI3{tree: tree, config: config}
+	m.normalizePercents(tree)
+	m.layout(tree)
+	return m
+}
+
+func loadMockTree(path string) (*i3.Node, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tree i3.Node
+	if err := json.Unmarshal(contents, &tree); err != nil {
+		return nil, fmt.Errorf("tree %s: %w", path, err)
+	}
+	return &tree, nil
+}
+
+func (m *mockI3) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go m.handle(&mockConn{Conn: conn})
+	}
+}
+
+func (m *mockI3) handle(conn *mockConn) {
+	defer m.unsubscribe(conn)
+	defer conn.Close()
+	for {
+		msgType, payload, err := readMockMessage(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("mock-i3: error reading message: %s", err.Error())
+			}
+			return
+		}
+		reply, err := m.reply(conn, msgType, payload)
+		if err != nil {
+			reply, _ = json.Marshal(mockCommandResult{false, err.Error()})
+		}
+		if err := conn.write(msgType, reply); err != nil {
+			log.Printf("mock-i3: error writing reply: %s", err.Error())
+			return
+		}
+		if msgType == mockSubscribe && conn.events[i3.TickEventType] {
+			// Like i3, greet new tick subscribers
+			m.send(conn, i3.TickEventType, i3.TickEvent{First: true})
+		}
+	}
+}
+
+func (m *mockI3) reply(conn *mockConn, msgType uint32, payload []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch msgType {
+	case mockRunCommand:
+		return json.Marshal(m.runCommand(string(payload)))
+	case mockGetTree:
+		return json.Marshal(m.tree)
+	case mockSubscribe:
+		var names []i3.EventType
+		if err := json.Unmarshal(payload, &names); err != nil {
+			return nil, err
+		}
+		conn.events = make(map[i3.EventType]bool)
+		for _, name := range names {
+			if _, ok := mockEventTypes[name]; !ok {
+				return nil, fmt.Errorf("unknown event type %q", name)
+			}
+			conn.events[name] = true
+		}
+		m.subscribers = append(m.subscribers, conn)
+		return json.Marshal(mockCommandResult{Success: true})
+	case mockGetVersion:
+		return json.Marshal(i3.Version{Major: 4, Minor: 18, HumanReadable: "4.18 (i3-flex mock)"})
+	case mockGetConfig:
+		return json.Marshal(i3.Config{Config: m.config})
+	case mockSendTick:
+		m.broadcast(i3.TickEventType, i3.TickEvent{Payload: string(payload)})
+		return json.Marshal(mockCommandResult{Success: true})
+	case mockSync:
+		return json.Marshal(mockCommandResult{Success: true})
+	}
+	return nil, fmt.Errorf("message type %d is not supported by the mock", msgType)
+}
+
+func (m *mockI3) unsubscribe(conn *mockConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, sub := range m.subscribers {
+		if sub == conn {
+			m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sends the event to everyone subscribed to it. Must be called holding m.mu.
+func (m *mockI3) broadcast(eventType i3.EventType, event interface{}) {
+	for _, sub := range m.subscribers {
+		if sub.events[eventType] {
+			m.send(sub, eventType, event)
+		}
+	}
+}
+
+func (m *mockI3) send(conn *mockConn, eventType i3.EventType, event interface{}) {
+	payload, err := json.Marshal(event)
+	if err == nil {
+		err = conn.write(1<<31|mockEventTypes[eventType], payload)
+	}
+	if err != nil {
+		log.Printf("mock-i3: error sending %s event: %s", eventType, err.Error())
+	}
+}
+
+func readMockMessage(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, len(mockMagic)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if string(header[:len(mockMagic)]) != mockMagic {
+		return 0, nil, fmt.Errorf("invalid magic %q", header[:len(mockMagic)])
+	}
+	length := binary.LittleEndian.Uint32(header[len(mockMagic):])
+	msgType := binary.LittleEndian.Uint32(header[len(mockMagic)+4:])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return msgType, payload, nil
+}
+
+func (c *mockConn) write(msgType uint32, payload []byte) error {
+	msg := make([]byte, len(mockMagic)+8, len(mockMagic)+8+len(payload))
+	copy(msg, mockMagic)
+	binary.LittleEndian.PutUint32(msg[len(mockMagic):], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(msg[len(mockMagic)+4:], msgType)
+	msg = append(msg, payload...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Write(msg)
+	return err
+}
+
+var (
+	mockCriteriaRegexp = regexp.MustCompile(`^\[con_id="?(\d+)"?\]\s*(.*)$`)
+	mockResizeRegexp   = regexp.MustCompile(`^resize set (width|height) (\d+) ppt$`)
+)
+
+// Runs each command of a chain, returning one result per command like i3 does.
+// Must be called holding m.mu.
+func (m *mockI3) runCommand(chain string) []mockCommandResult {
+	results := make([]mockCommandResult, 0)
+	for _, cmd := range strings.Split(chain, ";") {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			continue
+		}
+		if err := m.runOne(cmd); err != nil {
+			results = append(results, mockCommandResult{false, err.Error()})
+		} else {
+			results = append(results, mockCommandResult{Success: true})
+		}
+	}
+	return results
+}
+
+func (m *mockI3) runOne(cmd string) error {
+	target := findFocused(m.tree)
+	if match := mockCriteriaRegexp.FindStringSubmatch(cmd); match != nil {
+		id, _ := strconv.ParseInt(match[1], 10, 64)
+		path := findPath(m.tree, i3.NodeID(id))
+		if path == nil {
+			return fmt.Errorf("no container matches con_id=%d", id)
+		}
+		target = path[len(path)-1]
+		cmd = match[2]
+	}
+	if target == nil {
+		return errors.New("no container to run the command on")
+	}
+
+	if cmd == "nop" || strings.HasPrefix(cmd, "nop ") {
+		return nil
+	}
+	if cmd == "focus" {
+		m.focus(target.ID)
+		return nil
+	}
+	if match := mockResizeRegexp.FindStringSubmatch(cmd); match != nil {
+		ppt, _ := strconv.Atoi(match[2])
+		layout := i3.SplitV
+		if match[1] == "width" {
+			layout = i3.SplitH
+		}
+		return m.resize(target.ID, layout, ppt)
+	}
+	return fmt.Errorf("command %q is not supported by the mock", cmd)
+}
+
+// Like i3, resizes the closest ancestor (or the container itself) which is split in the right direction,
+// taking the space from, or giving it to, its siblings proportionally.
+func (m *mockI3) resize(id i3.NodeID, layout i3.Layout, ppt int) error {
+	path := findPath(m.tree, id)
+	for i := len(path) - 1; i > 0; i-- {
+		parent, con := path[i-1], path[i]
+		if parent.Layout != layout {
+			if parent.Type == i3.WorkspaceNode {
+				break
+			}
+			continue
+		}
+		if len(parent.Nodes) < 2 {
+			return errors.New("no second container found in this direction")
+		}
+		if ppt <= 0 || ppt >= 100 {
+			return fmt.Errorf("invalid size %d ppt", ppt)
+		}
+		percent := float64(ppt) / 100
+		rest := 1 - con.Percent
+		for _, sibling := range parent.Nodes {
+			if sibling == con {
+				continue
+			}
+			if rest > 0 {
+				sibling.Percent = sibling.Percent * (1 - percent) / rest
+			} else {
+				sibling.Percent = (1 - percent) / float64(len(parent.Nodes)-1)
+			}
+		}
+		con.Percent = percent
+		m.layout(parent)
+		return nil
+	}
+	return fmt.Errorf("no container is split %s above con_id=%d", layout, id)
+}
+
+// Moves the focus to the container, and tells subscribers
+func (m *mockI3) focus(id i3.NodeID) {
+	path := findPath(m.tree, id)
+	if focused := findFocused(m.tree); focused != nil {
+		focused.Focused = false
+	}
+	for i := 0; i < len(path)-1; i++ {
+		child := path[i+1].ID
+		focus := []i3.NodeID{child}
+		for _, v := range path[i].Focus {
+			if v != child {
+				focus = append(focus, v)
+			}
+		}
+		path[i].Focus = focus
+	}
+	con := path[len(path)-1]
+	con.Focused = true
+	m.broadcast(i3.WindowEventType, i3.WindowEvent{Change: "focus", Container: *con})
+}
+
+// Hand written fixtures may leave out percents, in which case children are split equally
+func (m *mockI3) normalizePercents(n *i3.Node) {
+	sum := 0.0
+	for _, child := range n.Nodes {
+		sum = sum + child.Percent
+		m.normalizePercents(child)
+	}
+	if len(n.Nodes) > 0 && math.Abs(sum-1) > 0.01 {
+		for _, child := range n.Nodes {
+			child.Percent = 1 / float64(len(n.Nodes))
+		}
+	}
+}
+
+// Recomputes the rects of split containers below n from their percents.
+// Gaps, borders and title bars are not simulated.
+func (m *mockI3) layout(n *i3.Node) {
+	if n.Layout == i3.SplitH || n.Layout == i3.SplitV {
+		offset := int64(0)
+		for i, child := range n.Nodes {
+			rect := n.Rect
+			if n.Layout == i3.SplitH {
+				rect.X = n.Rect.X + offset
+				rect.Width = int64(math.Round(child.Percent * float64(n.Rect.Width)))
+				if i == len(n.Nodes)-1 {
+					rect.Width = n.Rect.Width - offset
+				}
+				offset = offset + rect.Width
+			} else {
+				rect.Y = n.Rect.Y + offset
+				rect.Height = int64(math.Round(child.Percent * float64(n.Rect.Height)))
+				if i == len(n.Nodes)-1 {
+					rect.Height = n.Rect.Height - offset
+				}
+				offset = offset + rect.Height
+			}
+			child.Rect = rect
+		}
+	} else if n.Layout == i3.Tabbed || n.Layout == i3.Stacked {
+		for _, child := range n.Nodes {
+			child.Rect = n.Rect
+		}
+	}
+	for _, child := range n.Nodes {
+		m.layout(child)
+	}
+}
+
+func defaultMockSocketPath() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("i3-flex-mock.%d.sock", os.Getpid()))
+}
+
+// The `i3-flex mock-i3` command
+func runMockI3(args []string) error {
+	flags := flag.NewFlagSet("mock-i3", flag.ExitOnError)
+	treePath := flags.String("tree", "", "path to a get_tree fixture (required)")
+	configPath := flags.String("i3-config", "", "path to an i3 config to serve for get_config")
+	socketPath := flags.String("socket", defaultMockSocketPath(), "path of the socket to listen on")
+	flags.Parse(args)
+
+	if *treePath == "" {
+		return errors.New("usage: mock-i3 --tree fixture.json [--socket path] [--i3-config path]")
+	}
+	tree, err := loadMockTree(*treePath)
+	if err != nil {
+		return err
+	}
+	config := ""
+	if *configPath != "" {
+		contents, err := ioutil.ReadFile(*configPath)
+		if err != nil {
+			return err
+		}
+		config = string(contents)
+	}
+
+	l, err := net.Listen("unix", *socketPath)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Printf("export I3SOCK=%s\n", *socketPath)
+	return newMockI3(tree, config).serve(l)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.i3wm.org/i3/v4"
+)
+
+func loadTestMock(t *testing.T) *mockI3 {
+	tree, err := loadMockTree(filepath.Join("testdata", "tree.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMockI3(tree, "")
+}
+
+func TestMockResize(t *testing.T) {
+	m := loadTestMock(t)
+	results := m.runCommand("[con_id=5] resize set width 70 ppt; [con_id=7] resize set height 25 ppt; [con_id=5] resize set height 50 ppt")
+	if len(results) != 3 || !results[0].Success || !results[1].Success || results[2].Success {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	editor := findPath(m.tree, 5)
+	con := editor[len(editor)-1]
+	if con.Rect.Width != 1344 {
+		t.Errorf("expected the editor to be 70%% of 1920, got %d", con.Rect.Width)
+	}
+	terminal := findPath(m.tree, 7)
+	con = terminal[len(terminal)-1]
+	if con.Rect.Width != 576 || con.Rect.Height != 270 {
+		t.Errorf("expected the terminal to be 576x270, got %dx%d", con.Rect.Width, con.Rect.Height)
+	}
+}
+
+func TestMockFocusEvent(t *testing.T) {
+	m := loadTestMock(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	go m.handle(&mockConn{Conn: server})
+
+	conn := &mockConn{Conn: client}
+	client.SetDeadline(time.Now().Add(time.Second))
+	if err := conn.write(mockSubscribe, []byte(`["window"]`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readMockMessage(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.write(mockRunCommand, []byte("[con_id=8] focus")); err != nil {
+		t.Fatal(err)
+	}
+
+	var ev i3.WindowEvent
+	for i := 0; i < 2; i++ {
+		msgType, payload, err := readMockMessage(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgType == 1<<31|mockEventTypes[i3.WindowEventType] {
+			if err := json.Unmarshal(payload, &ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if ev.Change != "focus" || ev.Container.ID != 8 {
+		t.Errorf("expected a focus event for con_id=8, got %+v", ev)
+	}
+	if focused := findFocused(m.tree); focused == nil || focused.ID != 8 {
+		t.Errorf("expected con_id=8 to be focused")
+	}
+}
@@ -13,10 +13,13 @@ type Ratio struct {
 	Dividend, Divisor int
 }
 
-// Returns the new dividend as if the Divisor was normal (1000)
+// Returns the new dividend as if the Divisor was normal (1000).
+// A ratio with a 0 Divisor normalizes to 0.
 func (r Ratio) Normalize() int {
 	scaled := r.Dividend
 	complement := r.Divisor - r.Dividend
-	rescale([]*int{&scaled, &complement}, r.Divisor, normal)
+	if err := rescale([]*int{&scaled, &complement}, r.Divisor, normal); err != nil {
+		return 0
+	}
 	return scaled
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"go.i3wm.org/i3/v4"
+)
+
+// A rule as written in the config, e.g.
+//
+//	[[rules]]
+//	class = "^Firefox$"
+//	soft_min_flex = 0.7
+//
+// Criteria are regular expressions, matched like i3's own criteria.
+// A rule applies to windows matching all of its criteria.
+type RuleConfig struct {
+	Class    string `toml:"class"`
+	Instance string `toml:"instance"`
+	Title    string `toml:"title"`
+	Role     string `toml:"window_role"`
+
+	SoftMinFlex   *float64 `toml:"soft_min_flex"`
+	SoftMinUnflex *float64 `toml:"soft_min_unflex"`
+	FlexRatio     *float64 `toml:"flex_ratio"`
+}
+
+// A compiled RuleConfig. Sizes <= 0 are left unspecified.
+type windowRule struct {
+	class, instance, title, role *regexp.Regexp
+
+	softMinFlex   Size
+	softMinUnflex Size
+	flexTarget    Size
+}
+
+func (c RuleConfig) compile(sizings GlobalSizings) (windowRule, error) {
+	var rule windowRule
+	criteria := []struct {
+		name    string
+		pattern string
+		re      **regexp.Regexp
+	}{
+		{"class", c.Class, &rule.class},
+		{"instance", c.Instance, &rule.instance},
+		{"title", c.Title, &rule.title},
+		{"window_role", c.Role, &rule.role},
+	}
+	matchesAnything := true
+	for _, criterion := range criteria {
+		if criterion.pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(criterion.pattern)
+		if err != nil {
+			return rule, fmt.Errorf("%s: %w", criterion.name, err)
+		}
+		*criterion.re = re
+		matchesAnything = false
+	}
+	if matchesAnything {
+		return rule, errors.New("a rule needs at least one of class, instance, title or window_role")
+	}
+
+	sizes := []struct {
+		name     string
+		value    *float64
+		size     *Size
+		min, max Size
+	}{
+		{"soft_min_flex", c.SoftMinFlex, &rule.softMinFlex, sizings.hardMinFlex, sizings.maxFlex},
+		{"soft_min_unflex", c.SoftMinUnflex, &rule.softMinUnflex, sizings.hardMinUnflex, normal - sizings.hardMinFlex},
+		{"flex_ratio", c.FlexRatio, &rule.flexTarget, sizings.hardMinFlex, sizings.maxFlex},
+	}
+	for _, s := range sizes {
+		if s.value == nil {
+			continue
+		}
+		size, err := fractionToSize(s.name, *s.value)
+		if err != nil {
+			return rule, err
+		}
+		if size < s.min || size > s.max {
+			return rule, fmt.Errorf("%s (%g) must be between %g and %g",
+				s.name, *s.value, float64(s.min)/normal, float64(s.max)/normal)
+		}
+		*s.size = size
+	}
+	// The flex target is raised to the soft minimum when applied, so a smaller ratio would be silently ignored
+	if rule.flexTarget > 0 {
+		min := sizings.softMinFlex
+		if rule.softMinFlex > 0 {
+			min = rule.softMinFlex
+		}
+		if rule.flexTarget < min {
+			return rule, fmt.Errorf("flex_ratio (%g) must be at least soft_min_flex (%g)",
+				*c.FlexRatio, float64(min)/normal)
+		}
+	}
+	return rule, nil
+}
+
+func (r windowRule) matches(props i3.WindowProperties) bool {
+	return (r.class == nil || r.class.MatchString(props.Class)) &&
+		(r.instance == nil || r.instance.MatchString(props.Instance)) &&
+		(r.title == nil || r.title.MatchString(props.Title)) &&
+		(r.role == nil || r.role.MatchString(props.Role))
+}
+
+// Applies every matching rule to a new item. Later rules override earlier ones.
+func applyRules(rules []windowRule, item *FlexItem, props i3.WindowProperties) {
+	for _, rule := range rules {
+		if !rule.matches(props) {
+			continue
+		}
+		logDebug(logModel, "Applying rule to [%d] class=[%s] title=[%s]", item.id, props.Class, props.Title)
+		if rule.softMinFlex > 0 {
+			item.softMinFlex = rule.softMinFlex
+		}
+		if rule.softMinUnflex > 0 {
+			item.softMinUnflex = rule.softMinUnflex
+		}
+		if rule.flexTarget > 0 {
+			item.flexTarget = rule.flexTarget
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 type Size int
 
 type GlobalSizings struct {
@@ -9,35 +15,223 @@ type GlobalSizings struct {
 	hardMinFlex      Size
 	softMinUnflex    Size
 	hardMinUnflex    Size
-}
 
-var globals GlobalSizings = GlobalSizings{}
+	resizeTolerance Size         // Changes up to this much aren't taken as resizes by the user
+	shrinkPolicy    shrinkPolicy // Who gets the space freed when the user shrinks an item
+
+	strategy Sizing // Used by models which don't have a strategy of their own
+}
 
-func init() {
-	globals.defaultFlexRatio = goldenRatio
-	globals.maxFlex = Size(Ratio{9, 10}.Normalize())
-	globals.softMinFlex = Size(goldenRatio.Normalize())
-	globals.hardMinFlex = Size(Ratio{1, 2}.Normalize() + 1)
-	globals.softMinUnflex = Size(Ratio{1, 10}.Normalize())
-	globals.hardMinUnflex = Size(Ratio{1, 20}.Normalize())
+// The sizings used when the config file doesn't override them
+func defaultGlobalSizings() GlobalSizings {
+	return GlobalSizings{
+		defaultFlexRatio: goldenRatio,
+		maxFlex:          Size(Ratio{9, 10}.Normalize()),
+		softMinFlex:      Size(goldenRatio.Normalize()),
+		hardMinFlex:      Size(Ratio{1, 2}.Normalize() + 1),
+		softMinUnflex:    Size(Ratio{1, 10}.Normalize()),
+		hardMinUnflex:    Size(Ratio{1, 20}.Normalize()),
+		resizeTolerance:  Size(Ratio{1, 100}.Normalize()),
+		shrinkPolicy:     proportionalShrinkPolicy,
+		strategy:         constraintSizing{},
+	}
 }
 
+type shrinkPolicy string
+
+const (
+	proportionalShrinkPolicy shrinkPolicy = "proportional" // Every other item, in proportion to its size
+	flexedShrinkPolicy       shrinkPolicy = "flexed"       // The flexed item, up to maxFlex
+	unflexedShrinkPolicy     shrinkPolicy = "unflexed"     // The other unflexed items, in proportion to their sizes
+)
+
+// A strategy for sizing the items of a model when one of them is flexed.
+//
+// Strategies only decide what happens on focus.
+// Resizes by the user are still learned as constraints, which only constraintSizing makes use of.
 type Sizing interface {
-	GetSize(idx, outOf int, isFlexed bool) Size
+	Name() string // As selected in the config, and by `ctl sizing`
+	// Flexes the item at idx, resizing the others to make room. Returns true if any sizes changed.
+	Flex(f *FlexModel, idx int) (bool, error)
 }
 
-// A sizing implementation which flexes the flexed element to a fixed flex size,
-// and makes the remaining elements share from the remaining unflexed size
-type SimpleSizing struct {
-	flexed   Size
-	unflexed Size
+// The built-in strategies, by name
+var sizingStrategies = map[string]Sizing{
+	"constraints": constraintSizing{},
+	"equal":       equalSizing{},
+	"golden":      goldenSizing{},
+	"fixed":       fixedSizing{},
+	"recency":     recencySizing{},
 }
 
-func (s *SimpleSizing) GetSize(idx, outOf int, isFlexed bool) Size {
-	if isFlexed {
-		return s.flexed
+func lookupSizing(name string) (Sizing, error) {
+	sizing, ok := sizingStrategies[name]
+	if !ok {
+		names := make([]string, 0, len(sizingStrategies))
+		for name := range sizingStrategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown sizing strategy %q, expected one of: %s", name, strings.Join(names, ", "))
 	}
-	return s.unflexed
+	return sizing, nil
 }
 
-var defaultSizing = SimpleSizing{1000, 500}
+// Flexes to the item's flex target, and shrinks the others while observing the minimums
+// learned from the user's resizes, invalidating them in turn when they don't fit.
+type constraintSizing struct{}
+
+func (constraintSizing) Name() string { return "constraints" }
+func (constraintSizing) Flex(f *FlexModel, idx int) (bool, error) {
+	return f.flexWithConstraints(idx)
+}
+
+// Splits the container evenly, regardless of focus
+type equalSizing struct{}
+
+func (equalSizing) Name() string { return "equal" }
+
+func (equalSizing) Flex(f *FlexModel, idx int) (bool, error) {
+	n := Size(len(f.items))
+	sizes := make([]Size, len(f.items))
+	for i := range sizes {
+		sizes[i] = normal / n
+		if Size(i) < normal%n {
+			sizes[i]++
+		}
+	}
+	return f.setSizes(sizes), nil
+}
+
+// Gives the flexed item the golden ratio of the container, then each of the others
+// the golden ratio of what's left, nearest to the flexed item first.
+type goldenSizing struct{}
+
+func (goldenSizing) Name() string { return "golden" }
+
+func (goldenSizing) Flex(f *FlexModel, idx int) (bool, error) {
+	order := make([]int, 0, len(f.items))
+	for i := range f.items {
+		order = append(order, i)
+	}
+	distance := func(i int) int {
+		if i > idx {
+			return i - idx
+		}
+		return idx - i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return distance(order[i]) < distance(order[j]) })
+
+	sizes := make([]Size, len(f.items))
+	rem := Size(normal)
+	for k, i := range order {
+		if k == len(order)-1 {
+			sizes[i] = rem
+			break
+		}
+		sizes[i] = rem * Size(goldenRatio.Normalize()) / normal
+		rem = rem - sizes[i]
+	}
+	f.raiseToHardMin(sizes, idx)
+	return f.setSizes(sizes), nil
+}
+
+// Gives the flexed item the default flex ratio, and splits the rest evenly,
+// ignoring everything learned about the items.
+type fixedSizing struct{}
+
+func (fixedSizing) Name() string { return "fixed" }
+
+func (fixedSizing) Flex(f *FlexModel, idx int) (bool, error) {
+	sizes := make([]Size, len(f.items))
+	if len(sizes) == 1 {
+		sizes[0] = normal
+		return f.setSizes(sizes), nil
+	}
+	flexed := Size(f.globals.defaultFlexRatio.Normalize())
+	if flexed > f.globals.maxFlex {
+		flexed = f.globals.maxFlex
+	}
+	sizes[idx] = flexed
+	n := Size(len(sizes) - 1)
+	rem := normal - flexed
+	k := Size(0)
+	for i := range sizes {
+		if i == idx {
+			continue
+		}
+		sizes[i] = rem / n
+		if k < rem%n {
+			sizes[i]++
+		}
+		k++
+	}
+	f.raiseToHardMin(sizes, idx)
+	return f.setSizes(sizes), nil
+}
+
+// Flexes to the item's flex target like constraintSizing, but gives the unflexed items
+// their minimum plus a share of what's left by how recently they were flexed,
+// halving with each item focused since.
+// Soft minimums give way to hard ones when they don't all fit.
+type recencySizing struct{}
+
+func (recencySizing) Name() string { return "recency" }
+
+func (recencySizing) Flex(f *FlexModel, idx int) (bool, error) {
+	sizes := make([]Size, len(f.items))
+	if len(sizes) == 1 {
+		sizes[0] = normal
+		return f.setSizes(sizes), nil
+	}
+	sizes[idx] = f.flexTarget(idx)
+
+	mins := make([]Size, len(sizes))
+	total := Size(0)
+	for i := range sizes {
+		if i != idx {
+			mins[i] = f.unflexTarget(i)
+			total = total + mins[i]
+		}
+	}
+	if total > normal-sizes[idx] {
+		total = 0
+		for i := range sizes {
+			if i != idx {
+				mins[i] = f.globals.hardMinUnflex
+				total = total + mins[i]
+			}
+		}
+	}
+	if total > normal-sizes[idx] {
+		// Too many items for the flex target. Only the hard minimums are kept
+		sizes[idx] = normal - total
+	}
+
+	// Weights are powers of two, so the least recent item has weight 1
+	ranks := f.recencyRanks()
+	weights := make([]int, len(sizes))
+	totalWeight := 0
+	for i := range sizes {
+		if i != idx {
+			weights[i] = 1 << uint(len(f.recent)-ranks[i])
+			totalWeight = totalWeight + weights[i]
+		}
+	}
+	surplus := normal - sizes[idx] - total
+	given := Size(0)
+	mostRecent := -1
+	for i := range sizes {
+		if i == idx {
+			continue
+		}
+		sizes[i] = mins[i] + surplus*Size(weights[i])/Size(totalWeight)
+		given = given + sizes[i] - mins[i]
+		if mostRecent < 0 || ranks[i] < ranks[mostRecent] {
+			mostRecent = i
+		}
+	}
+	// Rounding leftovers go to the most recent
+	sizes[mostRecent] = sizes[mostRecent] + surplus - given
+	return f.setSizes(sizes), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const stateFileVersion = 1
+
+// The contents of the state file
+type stateFile struct {
+	Version int              `json:"version"`
+	Models  []FlexModelState `json:"models"`
+}
+
+// Returns $XDG_STATE_HOME/i3-flex/state.json, falling back to ~/.local/state
+func defaultStatePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "i3-flex", "state.json")
+}
+
+// Reads the saved model states. A missing file is not an error, there's just nothing to restore.
+func loadState(path string) ([]FlexModelState, error) {
+	contents, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state stateFile
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return nil, fmt.Errorf("state %s: %w", path, err)
+	}
+	if state.Version != stateFileVersion {
+		return nil, fmt.Errorf("state %s: unsupported version %d", path, state.Version)
+	}
+	return state.Models, nil
+}
+
+func encodeState(models []FlexModelState) ([]byte, error) {
+	return json.MarshalIndent(stateFile{stateFileVersion, models}, "", "  ")
+}
+
+// Writes the state file, replacing it atomically so a crash can't leave it half written
+func writeState(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".state-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Saves the state of all models if it changed since the last save.
+// Must be called holding d.mu.
+func (d *daemon) persist() {
+	if d.statePath == "" {
+		return
+	}
+	contents, err := encodeState(d.fm.State())
+	if err != nil {
+		logError(logEvents, "Error encoding state: %s", err.Error())
+		return
+	}
+	if bytes.Equal(contents, d.savedState) {
+		return
+	}
+	if err := writeState(d.statePath, contents); err != nil {
+		logError(logEvents, "Error saving state to %s: %s", d.statePath, err.Error())
+		return
+	}
+	d.savedState = contents
+}
+
+// Restores models saved by a previous daemon into the freshly synced models,
+// and renders them so the layout matches what was restored.
+// Must be called holding d.mu.
+func (d *daemon) restore() error {
+	if d.statePath == "" {
+		return nil
+	}
+	states, err := loadState(d.statePath)
+	if err != nil {
+		return err
+	}
+	restored := d.fm.Restore(states)
+	if len(restored) > 0 {
+		d.fm.render(restored)
+	}
+	d.persist()
+	return nil
+}
@@ -1,18 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 )
 
 // Tries to distribute the delta to all sizes, moving it towards 0.
 // Modifies nums in place, respecting their corresponding mins
 // Returns the remaining delta value
 // In general it tries to just distribute the surplus of nums over mins evenly
-func rebalance(nums []*Size, mins []Size, delta Size) Size {
+func rebalance(nums []*Size, mins []Size, delta Size) (Size, error) {
 
 	if delta == 0 {
-		return 0
+		return 0, nil
 	}
 
 	if delta > 0 {
@@ -22,27 +22,35 @@ func rebalance(nums []*Size, mins []Size, delta Size) Size {
 		for _, num := range nums {
 			oldScale = oldScale + int(*num)
 		}
-		rescale(numsCpy, oldScale, int(delta))
+		if err := rescale(numsCpy, oldScale, int(delta)); err != nil {
+			return delta, err
+		}
 		for i, n := range numsCpy {
 			*nums[i] = *nums[i] + Size(*n)
 		}
 
-		return 0
+		return 0, nil
 	} else {
 		surpluses := make([]*int, len(nums))
 		totalSurplus := 0
-		log.Printf("rebalancing delta=%d", delta)
+		logTrace(logRebalance, "rebalancing delta=%d", delta)
 		for i, _ := range nums {
-			log.Printf("rebalancing num=[%d] min=[%d]", *nums[i], mins[i])
+			logTrace(logRebalance, "rebalancing num=[%d] min=[%d]", *nums[i], mins[i])
 		}
 
+		// Sizes already below their min have no surplus, and are left as they are
+		bases := make([]Size, len(nums))
 		for i, num := range nums {
+			bases[i] = mins[i]
+			if *num < mins[i] {
+				bases[i] = *num
+			}
 			surpluses[i] = new(int)
-			*surpluses[i] = int(*num - mins[i])
+			*surpluses[i] = int(*num - bases[i])
 			totalSurplus = totalSurplus + *surpluses[i]
 		}
 		if totalSurplus == 0 { // can't make a dent
-			return delta
+			return delta, nil
 		}
 		oldScale := totalSurplus
 		newScale := totalSurplus + int(delta)
@@ -51,11 +59,13 @@ func rebalance(nums []*Size, mins []Size, delta Size) Size {
 			remDelta = Size(newScale)
 			newScale = 0
 		}
-		rescale(surpluses, oldScale, newScale)
+		if err := rescale(surpluses, oldScale, newScale); err != nil {
+			return delta, err
+		}
 		for i, n := range surpluses {
-			*nums[i] = mins[i] + Size(*n)
+			*nums[i] = bases[i] + Size(*n)
 		}
-		return remDelta
+		return remDelta, nil
 	}
 }
 
@@ -69,35 +79,36 @@ func copySizes(nums []*Size) []*int {
 	return ints
 }
 
-func checkScaleSizes(nums []*Size) {
+func checkScaleSizes(nums []*Size) error {
 	// Assert size
 	ints := make([]*int, len(nums))
 	for k, v := range nums {
 		ints[k] = (*int)(v)
 	}
-	checkScale(ints, normal)
+	return checkScale(ints, normal)
 }
 
-func checkScale(nums []*int, oldScale int) {
+func checkScale(nums []*int, oldScale int) error {
 	sum := 0
 	for _, v := range nums {
 		sum = sum + *v
 	}
 	if sum != oldScale {
-		panic(fmt.Sprintf("Expected nums to sum to %d, got %d", oldScale, sum))
+		return fmt.Errorf("expected nums to sum to %d, got %d", oldScale, sum)
 	}
+	return nil
 }
 
-func rescaleSizes(nums []*Size, newScale int) {
+func rescaleSizes(nums []*Size, newScale int) error {
 	// Assert size
 	ints := make([]*int, len(nums))
 	for k, v := range nums {
 		ints[k] = (*int)(v)
 	}
-	rescale(ints, normal, newScale)
+	return rescale(ints, normal, newScale)
 }
 
-func rescale(nums []*int, oldScale, newScale int) {
+func rescale(nums []*int, oldScale, newScale int) error {
 
 	if oldScale == 0 {
 		// if oldScale is 0, we infer it from the nums assuming they add up to their scale
@@ -110,15 +121,15 @@ func rescale(nums []*int, oldScale, newScale int) {
 			total = total + *v
 		}
 		if total != oldScale {
-			panic("oldScale specifies but it doesnt add up")
+			return fmt.Errorf("nums add up to %d, not the old scale %d", total, oldScale)
 		}
 	}
-	log.Printf("Scaling oldScale=[%d] newScale=[%d]", oldScale, newScale)
+	logTrace(logRebalance, "Scaling oldScale=[%d] newScale=[%d]", oldScale, newScale)
 	if oldScale == 0 {
-		panic("oldScale cannot be 0, results in divide by 0")
+		return errors.New("cannot rescale from 0")
 	}
 	for _, v := range nums {
-		log.Printf("num=[%d]", *v)
+		logTrace(logRebalance, "num=[%d]", *v)
 	}
 	for _, v := range nums {
 		scaled := float64(*v) * (float64(newScale) / float64(oldScale))
@@ -131,20 +142,20 @@ func rescale(nums []*int, oldScale, newScale int) {
 	// Since we floored it's possible we'll have some remaining, distribute evenly
 	rem := newScale - sum
 	if rem > 10 {
-		log.Printf("hmm, David probably sucks at math")
-		log.Printf("rem=%d", rem)
+		logDebug(logRebalance, "hmm, David probably sucks at math. rem=%d", rem)
 	}
 	for rem > 0 {
 		for _, v := range nums {
 			*v = *v + 1
 			rem--
 			if rem == 0 {
-				return
+				return nil
 			}
 		}
 	}
-	log.Printf("Done scaling")
+	logTrace(logRebalance, "Done scaling")
 	for _, v := range nums {
-		log.Printf("num=[%d]", *v)
+		logTrace(logRebalance, "num=[%d]", *v)
 	}
+	return nil
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+// `watch` is the one control command which keeps its connection open.
+// The daemon answers it with a ctlResponse holding the views of all models,
+// and another one every time they may have changed.
+const watchCommand = "watch"
+
+// Streams model views to a watch client, until it goes away
+func (d *daemon) watch(conn net.Conn) {
+	frames := make(chan []modelView, 1)
+	d.mu.Lock()
+	d.watchers[frames] = true
+	frames <- d.fm.views()
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.watchers, frames)
+		d.mu.Unlock()
+	}()
+
+	// Clients don't send anything more, so reading only ends when they disconnect.
+	// Closing the connection then fails the next write.
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}()
+
+	enc := json.NewEncoder(conn)
+	for views := range frames {
+		result, err := json.Marshal(views)
+		if err != nil {
+			return
+		}
+		if err := enc.Encode(ctlResponse{Result: result}); err != nil {
+			return
+		}
+	}
+}
+
+// Sends the current views to every watcher. Watchers which are behind only get the latest.
+// Must be called holding d.mu.
+func (d *daemon) publish() {
+	if len(d.watchers) == 0 {
+		return
+	}
+	views := d.fm.views()
+	for frames := range d.watchers {
+		select {
+		case <-frames:
+		default:
+		}
+		frames <- views
+	}
+}
+
+// Redraws the daemon's models on the terminal whenever they change
+func runWatch(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	width := flags.Int("width", 50, "width of the bars")
+	flags.Parse(args)
+	if *width < 10 {
+		return errors.New("width must be at least 10")
+	}
+
+	conn, err := dialCtl()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(ctlRequest{Command: watchCommand}); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var resp ctlResponse
+		if err := dec.Decode(&resp); err != nil {
+			if err == io.EOF {
+				return errors.New("the daemon closed the connection")
+			}
+			return err
+		}
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		var views []modelView
+		if err := json.Unmarshal(resp.Result, &views); err != nil {
+			return err
+		}
+		fmt.Print("\033[H\033[2J") // Clear the terminal
+		drawModels(os.Stdout, views, *width)
+	}
+}
+
+// Draws each model as one bar per item, showing its share of the container
+func drawModels(out io.Writer, models []modelView, width int) {
+	if len(models) == 0 {
+		fmt.Fprintln(out, "No flex models")
+	}
+	for _, model := range models {
+		fmt.Fprintf(out, "model [%d] %s", model.ID, model.Direction)
+		if model.Sizing != "" {
+			fmt.Fprintf(out, " sizing=%s", model.Sizing)
+		}
+		fmt.Fprintln(out)
+		for i, item := range model.Items {
+			tags := make([]string, 0, 2)
+			if item.Flexed {
+				tags = append(tags, "flexed")
+			}
+			if item.Pinned {
+				tags = append(tags, "pinned")
+			}
+			fmt.Fprintf(out, "  %-10s %s %4d %3d%% min %4d %s\n",
+				fmt.Sprintf("[%d]", item.ID), bar(item.Current, model.Minimums[i], item.Flexed, width),
+				item.Current, model.Ppt[i], model.Minimums[i], strings.Join(tags, " "))
+		}
+		if len(model.Invalidated) > 0 {
+			fmt.Fprintf(out, "  invalidated: %s\n", strings.Join(model.Invalidated, ", "))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// Draws the size as a bar of # if flexed, or = if not, with a | where the minimum is
+func bar(current Size, min Size, flexed bool, width int) string {
+	fill := '='
+	if flexed {
+		fill = '#'
+	}
+	cells := []rune(strings.Repeat(" ", width))
+	for i := 0; i < int(current)*width/normal && i < width; i++ {
+		cells[i] = fill
+	}
+	if m := int(min) * width / normal; m >= 0 && m < width {
+		cells[m] = '|'
+	}
+	return "[" + string(cells) + "]"
+}